@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/models"
+	"github.com/spf13/cobra"
+)
+
+var cmdDryRun = &cobra.Command{
+	Use:       "dry-run username on|off",
+	Short:     "store the given user's messages without sending them, or resume sending",
+	Args:      cobra.ExactValidArgs(2),
+	ValidArgs: []string{"on", "off"},
+	Run: func(cmd *cobra.Command, args []string) {
+		user, err := models.LoadUser(Db(), args[0])
+		if err != nil {
+			log.Log.Fatalf("loading user %q: %v", args[0], err)
+		}
+
+		switch args[1] {
+		case "on":
+			user.DryRun = true
+		case "off":
+			user.DryRun = false
+		default:
+			log.Log.Fatalf("expected on or off, not %q", args[1])
+		}
+
+		if err := user.Save(Db()); err != nil {
+			log.Log.Fatalf("could not save user: %v", err)
+		}
+		log.Log.Infof("dry run for %q is %s", user.Username, args[1])
+	},
+}
+
+func init() {
+	root.AddCommand(cmdDryRun)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/models"
+	"github.com/spf13/cobra"
+)
+
+var queueConfig struct {
+	Status   string
+	Username string
+	Bodies   bool
+}
+
+var queue = &cobra.Command{
+	Use:   "queue",
+	Short: "inspect and replay the outbound message queue",
+}
+
+var queueList = &cobra.Command{
+	Use:   "list",
+	Short: "list messages in the outbound queue",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		msgs, err := models.ListOutbox(Db(), func(m *models.OutboundMessage) bool {
+			if queueConfig.Status != "" && string(m.Status) != queueConfig.Status {
+				return false
+			}
+			return queueConfig.Username == "" || m.Username == strings.ToLower(queueConfig.Username)
+		})
+		if err != nil {
+			log.Log.Fatalf("listing queue: %v", err)
+		}
+
+		for _, m := range msgs {
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\tattempts=%d\tnext=%s\n",
+				m.Id, m.Status, m.Username, m.Channel, m.Kind, m.Attempts,
+				m.NextAttempt.Format(time.RFC3339))
+			if m.LastError != "" {
+				fmt.Println("    error: ", m.LastError)
+			}
+			if queueConfig.Bodies {
+				fmt.Println("    body:  ", m.Body)
+			}
+		}
+	},
+}
+
+var queueReplay = &cobra.Command{
+	Use:   "replay message-id [message-id...]",
+	Short: "re-queue sent or dead-lettered messages for immediate delivery",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, arg := range args {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				log.Log.Fatalf("expected numeric message ID but could not parse %q: %v", arg, err)
+			}
+			if err := models.ReplayMessage(Db(), id); err != nil {
+				log.Log.Fatalf("replaying message %d: %v", id, err)
+			}
+			log.Log.Infof("message %d re-queued", id)
+		}
+	},
+}
+
+func init() {
+	queueList.Flags().StringVar(
+		&queueConfig.Status,
+		"status",
+		"",
+		"if set, only list messages with this status (pending, sent, dead, superseded, dry-run)",
+	)
+	queueList.Flags().StringVar(
+		&queueConfig.Username,
+		"user",
+		"",
+		"if set, only list messages for this user",
+	)
+	queueList.Flags().BoolVar(
+		&queueConfig.Bodies,
+		"bodies",
+		false,
+		"if true, include message bodies",
+	)
+	queue.AddCommand(queueList, queueReplay)
+	root.AddCommand(queue)
+}
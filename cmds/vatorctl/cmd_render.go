@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/models"
+	"github.com/spf13/cobra"
+)
+
+var cmdRender = &cobra.Command{
+	Use:   "render username",
+	Short: "print what the given user would be sent right now, without sending or recording anything",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user, err := models.LoadUser(Db(), args[0])
+		if err != nil {
+			log.Log.Fatalf("loading user %q: %v", args[0], err)
+		}
+
+		if user.Paused {
+			fmt.Println("(notifications are paused; nothing would actually be sent)")
+		}
+
+		// The user is never saved, so the milestones and templates picked
+		// here don't count as sent.
+		now := time.Now()
+		for _, note := range user.ToastNotifications(now) {
+			fmt.Printf("%s:\n%s\n\n", note.Kind, note.Body)
+		}
+		fmt.Printf("%s summary:\n%s\n", user.SummaryCadence, user.SummaryMessage(now, user.SummaryCadence.Days()))
+	},
+}
+
+func init() {
+	root.AddCommand(cmdRender)
+}
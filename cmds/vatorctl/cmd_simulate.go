@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/models"
+	"github.com/spf13/cobra"
+	"go.etcd.io/bbolt"
+)
+
+var simulateConfig struct {
+	From      string
+	To        string
+	Summaries bool
+	BaseUrl   string
+	Json      bool
+	Seed      int64
+}
+
+// simulatedMessage is one line of simulate's output.
+type simulatedMessage struct {
+	Time     time.Time `json:"time"`
+	Weight   string    `json:"weight,omitempty"`
+	Kind     string    `json:"kind"`
+	Template string    `json:"template,omitempty"`
+	Body     string    `json:"body"`
+}
+
+var cmdSimulate = &cobra.Command{
+	Use:   "simulate username",
+	Short: "replay a user's weigh-ins and print the messages vator would have sent",
+	Long: "Replays the user's recorded weights in order, as if each had just arrived, under a simulated clock " +
+		"that steps from each weigh-in to the next scheduler tick, and prints the toasts, encouragements, " +
+		"milestones, summaries, reports and reminders that would have been sent. Nothing is sent or saved. The " +
+		"user's current settings, e.g. units and summary cadence, apply throughout.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user, err := models.LoadUser(Db(), args[0])
+		if err != nil {
+			log.Log.Fatalf("loading user %q: %v", args[0], err)
+		}
+		rand.Seed(simulateConfig.Seed)
+
+		weights := append([]models.Weight(nil), user.Weights...)
+		sort.Slice(weights, func(i, j int) bool { return weights[i].Date.Before(weights[j].Date) })
+		if len(weights) == 0 {
+			log.Log.Fatalf("user %q has no weights to replay", user.Username)
+		}
+
+		tz := user.Timezone()
+		from, to := weights[0].Date, weights[len(weights)-1].Date.AddDate(0, 0, 1)
+		if simulateConfig.From != "" {
+			if from, err = time.ParseInLocation("2006-01-02", simulateConfig.From, tz); err != nil {
+				log.Log.Fatalf("--from: %v", err)
+			}
+		}
+		if simulateConfig.To != "" {
+			if to, err = time.ParseInLocation("2006-01-02", simulateConfig.To, tz); err != nil {
+				log.Log.Fatalf("--to: %v", err)
+			}
+		}
+
+		// Start over from a user with the same settings, but no history. With
+		// no channels, each message is rendered once, not once per channel.
+		user.Weights = nil
+		user.LastWeight = time.Time{}
+		user.LastSummary = from
+		user.LastMonthlyReport = from
+		user.LastYearlyReport = from
+		user.RemindersSent = 0
+		user.LastReminder = time.Time{}
+		user.Phone = ""
+		user.TelegramChatId = 0
+		user.Milestones = nil
+		user.MilestoneStartKgs = 0
+		user.LowestTrend = 0
+		user.LastLowMilestone = time.Time{}
+		user.BmiCategory = ""
+		user.RecentTemplates = nil
+
+		clock := models.NewFakeClock(from)
+
+		emit := func(m simulatedMessage) {
+			if simulateConfig.Json {
+				if err := json.NewEncoder(os.Stdout).Encode(m); err != nil {
+					log.Log.Fatalf("writing output: %v", err)
+				}
+				return
+			}
+			fmt.Printf("%s\t%s\t%-16s\t%s\n",
+				m.Time.In(tz).Format("2006-01-02 Mon 15:04"), m.Weight, m.Kind,
+				strings.ReplaceAll(m.Body, "\n", " / "))
+		}
+
+		// The scheduler's jobs run against a scratch database holding a copy
+		// of the user, through a dry-run notifier, so that nothing is sent or
+		// saved; what they would have sent is read back from its outbox.
+		scratch, err := os.MkdirTemp("", "vatorctl-simulate")
+		if err != nil {
+			log.Log.Fatalf("creating scratch directory: %v", err)
+		}
+		defer os.RemoveAll(scratch)
+		simDb, err := bbolt.Open(filepath.Join(scratch, "vator.db"), 0600, nil)
+		if err != nil {
+			log.Log.Fatalf("opening scratch database: %v", err)
+		}
+		defer simDb.Close()
+
+		notifier := models.NewNotifier(simDb, nil, nil)
+		notifier.DryRun = true
+		notifier.Clock = clock
+		var jobs []models.Job
+		if simulateConfig.Summaries {
+			jobs = append(jobs, models.SummaryJob(simDb, notifier))
+		}
+		jobs = append(jobs,
+			models.ReportJob(simDb, notifier, models.ReportMonth, simulateConfig.BaseUrl),
+			models.ReportJob(simDb, notifier, models.ReportYear, simulateConfig.BaseUrl),
+			models.ReminderJob(simDb, notifier))
+
+		// schedule runs the jobs that come due before until, stepping the
+		// clock from one scheduler tick to the next as the scheduler would,
+		// and prints what they send.
+		var printed uint64
+		schedule := func(until time.Time) {
+			// Bring the scratch copy up to date with the weigh-ins so far.
+			if err := user.Save(simDb); err != nil {
+				log.Log.Fatalf("copying user to scratch database: %v", err)
+			}
+			for {
+				now := clock.Now()
+				var next time.Time
+				for _, job := range jobs {
+					due := job.Next(user, now)
+					if due.IsZero() {
+						continue
+					}
+					if !due.After(now) {
+						job.Run(user)
+						if due = job.Next(user, now); due.IsZero() || !due.After(now) {
+							continue
+						}
+					}
+					if next.IsZero() || due.Before(next) {
+						next = due
+					}
+				}
+
+				msgs, err := models.ListOutbox(simDb, func(m *models.OutboundMessage) bool { return m.Id > printed })
+				if err != nil {
+					log.Log.Fatalf("reading scratch outbox: %v", err)
+				}
+				for _, m := range msgs {
+					emit(simulatedMessage{Time: m.Created, Kind: m.Kind, Template: m.Template, Body: m.Body})
+					printed = m.Id
+				}
+
+				if next.IsZero() || !next.Before(until) {
+					return
+				}
+				clock.Set(next)
+			}
+		}
+
+		for _, w := range weights {
+			if w.Date.Before(from) {
+				user.Weights = append(user.Weights, w)
+				continue
+			}
+			if !w.Date.Before(to) {
+				break
+			}
+
+			if clock.Now().Before(w.Date) {
+				schedule(w.Date)
+				clock.Set(w.Date)
+			}
+			user.Weights = append(user.Weights, w)
+			user.LastWeight = w.Date
+
+			weight := user.FormatKg(w.Kgs) + user.Unit()
+			notes := user.ToastNotifications(w.Date)
+			if len(notes) == 0 {
+				emit(simulatedMessage{Time: w.Date, Weight: weight, Kind: "-"})
+			}
+			for _, note := range notes {
+				emit(simulatedMessage{Time: w.Date, Weight: weight, Kind: note.Kind, Template: note.Template, Body: note.Body})
+			}
+		}
+		schedule(to)
+	},
+}
+
+func init() {
+	cmdSimulate.Flags().StringVar(&simulateConfig.From, "from", "",
+		"if set, only print messages from this date (YYYY-MM-DD) on; earlier weights still count towards averages")
+	cmdSimulate.Flags().StringVar(&simulateConfig.To, "to", "",
+		"if set, stop replaying at this date (YYYY-MM-DD)")
+	cmdSimulate.Flags().BoolVar(&simulateConfig.Summaries, "summaries", true,
+		"if true, include summaries at the user's chosen cadence")
+	cmdSimulate.Flags().StringVar(&simulateConfig.BaseUrl, "base-url", "http://localhost/",
+		"where links in reports point")
+	cmdSimulate.Flags().BoolVar(&simulateConfig.Json, "json", false,
+		"if true, print one JSON object per message, for comparing runs")
+	cmdSimulate.Flags().Int64Var(&simulateConfig.Seed, "seed", 1,
+		"seed for choosing among templates, so that runs are repeatable")
+	root.AddCommand(cmdSimulate)
+}
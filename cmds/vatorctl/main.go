@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
+	"os"
 	"time"
 
+	"github.com/asymmetricia/vator/config"
 	"github.com/asymmetricia/vator/log"
 	"github.com/spf13/cobra"
 	"go.etcd.io/bbolt"
@@ -10,9 +13,13 @@ import (
 
 var root = &cobra.Command{
 	Use: "vatorctl",
+	Long: "vatorctl inspects and changes vator's database directly. bolt allows only one process to\n" +
+		"open a database at a time, so stop vator first; vatorctl gives up if it can't open the\n" +
+		"database within a few seconds.",
 }
 
 var VatorctlConfig struct {
+	ConfigPath string
 	BoltDbPath string
 }
 
@@ -23,9 +30,21 @@ func Db() *bbolt.DB {
 		return db
 	}
 
+	// Without -db-path, the database is the one vator is configured with.
+	if VatorctlConfig.BoltDbPath == "" {
+		cfg, err := config.Load(VatorctlConfig.ConfigPath)
+		if err != nil {
+			log.Log.Fatalf("could not load configuration: %v", err)
+		}
+		VatorctlConfig.BoltDbPath = cfg.DbFile
+	}
+
 	openedDb, err := bbolt.Open(VatorctlConfig.BoltDbPath, 0600, &bbolt.Options{
 		Timeout: 5 * time.Second,
 	})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		log.Log.Fatalf("db at %q is locked; stop vator before using vatorctl", VatorctlConfig.BoltDbPath)
+	}
 	if err != nil {
 		log.Log.Fatalf("could not open db at %q: %v", VatorctlConfig.BoltDbPath, err)
 	}
@@ -34,11 +53,17 @@ func Db() *bbolt.DB {
 }
 
 func main() {
+	root.PersistentFlags().StringVar(
+		&VatorctlConfig.ConfigPath,
+		"config",
+		os.Getenv(config.FileEnv),
+		"path to vator's configuration file, which gives the database to operate on",
+	)
 	root.PersistentFlags().StringVar(
 		&VatorctlConfig.BoltDbPath,
 		"db-path",
-		"/opt/vator/vator.db",
-		"path to the vator.db file to operate on",
+		"",
+		"path to the vator.db file to operate on; overrides the configuration",
 	)
 
 	defer func() {
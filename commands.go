@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+// logWeightCommand records the weight given in args, in the user's preferred
+// unit, and toasts it like any other weigh-in. The returned string is the
+// reply to send back over whichever channel the command arrived on.
+func logWeightCommand(db *bbolt.DB, notifier *models.Notifier, user *models.User, command string, args []string) string {
+	if len(args) == 0 {
+		return fmt.Sprintf("What did you weigh? Try %s 180%s", command, user.Unit())
+	}
+
+	kgs, err := user.ParseWeight(strings.Join(args, ""))
+	if err != nil {
+		return fmt.Sprintf("Sorry, I couldn't use that: %s", err)
+	}
+
+	updated, err := models.UpdateUser(db, user.Username, func(u *models.User) error {
+		u.LogWeight(kgs, notifier.Clock.Now())
+		return nil
+	})
+	if err != nil {
+		Log.Errorf("saving manual weight for %q: %s", user.Username, err)
+		return "very sorry! afraid something went wrong..."
+	}
+
+	notifier.Go(func() { updated.Toast(notifier) })
+	return fmt.Sprintf("Got it, logged %s%s.", user.FormatKg(kgs), user.Unit())
+}
@@ -0,0 +1,345 @@
+// Package config is vator's configuration, read from a TOML or YAML file,
+// then from the environment, then from flags, each overriding the last.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/asymmetricia/vator/log"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable that gives the configuration file,
+// if -config does not.
+const FileEnv = "VATOR_CONFIG"
+
+// Redacted replaces secrets in printed configuration.
+const Redacted = "REDACTED"
+
+// Config is everything vator can be configured with. Each field's toml tag is
+// both its key in a configuration file and the name of its flag; its env tag
+// is the environment variable that sets it, prefixed with VATOR_ so as not to
+// pick up variables like $PORT that hosting platforms set for themselves. Fields tagged secret are redacted
+// when printed.
+type Config struct {
+	ConsumerKey    string `toml:"consumer-key" yaml:"consumer-key" env:"VATOR_CONSUMER_KEY" secret:"true"`
+	ConsumerSecret string `toml:"consumer-secret" yaml:"consumer-secret" env:"VATOR_CONSUMER_SECRET" secret:"true"`
+
+	Port           int    `toml:"port" yaml:"port" env:"VATOR_PORT"`
+	CallbackDomain string `toml:"callback-domain" yaml:"callback-domain" env:"VATOR_CALLBACK_DOMAIN"`
+	CallbackPort   int    `toml:"callback-port" yaml:"callback-port" env:"VATOR_CALLBACK_PORT"`
+	CallbackProto  string `toml:"callback-proto" yaml:"callback-proto" env:"VATOR_CALLBACK_PROTO"`
+	Tls            bool   `toml:"tls" yaml:"tls" env:"VATOR_TLS"`
+	DbFile         string `toml:"db-file" yaml:"db-file" env:"VATOR_DB_FILE"`
+
+	TwilioSid              string `toml:"twilio-sid" yaml:"twilio-sid" env:"VATOR_TWILIO_SID"`
+	TwilioToken            string `toml:"twilio-token" yaml:"twilio-token" env:"VATOR_TWILIO_TOKEN" secret:"true"`
+	TwilioFrom             string `toml:"twilio-from" yaml:"twilio-from" env:"VATOR_TWILIO_FROM"`
+	TwilioMessagingService string `toml:"twilio-messaging-service" yaml:"twilio-messaging-service" env:"VATOR_TWILIO_MESSAGING_SERVICE"`
+	TwilioApi              string `toml:"twilio-api" yaml:"twilio-api" env:"VATOR_TWILIO_API"`
+
+	TelegramToken string `toml:"telegram-token" yaml:"telegram-token" env:"VATOR_TELEGRAM_TOKEN" secret:"true"`
+	TelegramApi   string `toml:"telegram-api" yaml:"telegram-api" env:"VATOR_TELEGRAM_API"`
+
+	MetricsToken string `toml:"metrics-token" yaml:"metrics-token" env:"VATOR_METRICS_TOKEN" secret:"true"`
+
+	DryRun       bool     `toml:"dry-run" yaml:"dry-run" env:"VATOR_DRY_RUN"`
+	MessagesDir  string   `toml:"messages-dir" yaml:"messages-dir" env:"VATOR_MESSAGES_DIR"`
+	DrainTimeout Duration `toml:"drain-timeout" yaml:"drain-timeout" env:"VATOR_DRAIN_TIMEOUT"`
+
+	LogFormat string `toml:"log-format" yaml:"log-format" env:"VATOR_LOG_FORMAT"`
+	LogLevel  string `toml:"log-level" yaml:"log-level" env:"VATOR_LOG_LEVEL"`
+}
+
+// Default is the configuration before any file, environment or flags.
+func Default() Config {
+	return Config{
+		CallbackDomain: "localhost",
+		CallbackProto:  "http",
+		DbFile:         "vator.db",
+		DrainTimeout:   Duration{8 * time.Second},
+		LogFormat:      "text",
+		LogLevel:       "info",
+	}
+}
+
+// flags defines a flag on fs for each of c's fields, which sets that field.
+func (c *Config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConsumerKey, "consumer-key", c.ConsumerKey, "oauth consumer key")
+	fs.StringVar(&c.ConsumerSecret, "consumer-secret", c.ConsumerSecret, "oauth consumer secret")
+
+	fs.IntVar(&c.Port, "port", c.Port, "port to listen on (if 0, actual port will depend on whether TLS is enabled or not)")
+	fs.StringVar(&c.CallbackDomain, "callback-domain", c.CallbackDomain, "fqdn for oauth callbacks")
+	fs.IntVar(&c.CallbackPort, "callback-port", c.CallbackPort, "callback port; if zero, -port if vator speaks -callback-proto itself, otherwise that protocol's usual port")
+	fs.StringVar(&c.CallbackProto, "callback-proto", c.CallbackProto, "protocol to use in requesting callbacks")
+	fs.BoolVar(&c.Tls, "tls", c.Tls, "if true, will configure TLS using a certificate from letsencrypt")
+	fs.StringVar(&c.DbFile, "db-file", c.DbFile, "path to the bolt database file used to persist state")
+
+	fs.StringVar(&c.TwilioSid, "twilio-sid", c.TwilioSid, "twilio account SID")
+	fs.StringVar(&c.TwilioToken, "twilio-token", c.TwilioToken, "twilio auth token")
+	fs.StringVar(&c.TwilioFrom, "twilio-from", c.TwilioFrom, "phone number to send SMS from; if blank, the account's first number is used")
+	fs.StringVar(&c.TwilioMessagingService, "twilio-messaging-service", c.TwilioMessagingService, "twilio messaging service SID to send SMS through; overrides -twilio-from")
+	fs.StringVar(&c.TwilioApi, "twilio-api", c.TwilioApi, "base URL of the twilio API; if blank, twilio's own")
+
+	fs.StringVar(&c.TelegramToken, "telegram-token", c.TelegramToken, "telegram bot token")
+	fs.StringVar(&c.TelegramApi, "telegram-api", c.TelegramApi, "base URL of the telegram bot API; if blank, telegram's own")
+
+	fs.StringVar(&c.MetricsToken, "metrics-token", c.MetricsToken, "if set, /metrics requires this bearer token")
+
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun, "if true, messages are rendered and stored in the outbox, but never sent")
+	fs.StringVar(&c.MessagesDir, "messages-dir", c.MessagesDir, "directory of message catalog files (e.g. en.json) overriding the built-in templates")
+	fs.Var(&c.DrainTimeout, "drain-timeout", "on shutdown, how long to wait for requests, scans and messages in progress to finish")
+
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log format: text or json")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level, optionally followed by levels for subsystems, e.g. info,scanner=debug,http=warn")
+}
+
+// Parse parses args with fs, which gains a flag for each setting plus
+// -config, and returns the configuration from the file named by -config or
+// $VATOR_CONFIG, the environment, and the flags given, in that order of
+// precedence.
+func Parse(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+	file := fs.String("config", os.Getenv(FileEnv), "path to a TOML or YAML configuration file")
+	c.flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// The flags given are set aside and applied again last, so that they
+	// override the file and environment.
+	given := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			given[f.Name] = f.Value.String()
+		}
+	})
+
+	c = Default()
+	if err := c.load(fs, *file); err != nil {
+		return nil, err
+	}
+	for name, value := range given {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+	c.resolve()
+	return &c, nil
+}
+
+// Load returns the configuration from the file at path, if path isn't
+// empty, and the environment; it's Parse without flags.
+func Load(path string) (*Config, error) {
+	c := Default()
+	fs := flag.NewFlagSet("vator", flag.ContinueOnError)
+	c.flags(fs)
+	if err := c.load(fs, path); err != nil {
+		return nil, err
+	}
+	c.resolve()
+	return &c, nil
+}
+
+// load reads the file at path, if any, then the environment into c. fs must
+// hold c's flags, which parse the environment's values.
+func (c *Config) load(fs *flag.FlagSet, path string) error {
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return err
+		}
+	}
+
+	t := reflect.TypeOf(*c)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := os.Getenv(field.Tag.Get("env"))
+		if value == "" {
+			continue
+		}
+		if err := fs.Set(field.Tag.Get("toml"), value); err != nil {
+			return fmt.Errorf("$%s: invalid value %q: %w", field.Tag.Get("env"), value, err)
+		}
+	}
+	return nil
+}
+
+// readFile reads a YAML file if path ends in .yaml or .yml, or TOML otherwise.
+// Unknown settings are an error, since they're most likely typos.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading configuration: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading configuration %q: %w", path, err)
+		}
+	default:
+		md, err := toml.NewDecoder(f).Decode(c)
+		if err != nil {
+			return fmt.Errorf("reading configuration %q: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("reading configuration %q: unknown settings %s", path, strings.Join(keys, ", "))
+		}
+	}
+	return nil
+}
+
+// resolve fills in the settings that default to others.
+func (c *Config) resolve() {
+	if c.Port == 0 {
+		if c.Tls {
+			c.Port = 443
+		} else {
+			c.Port = 80
+		}
+	}
+	// Callbacks reach vator directly if it speaks callback-proto itself;
+	// otherwise, e.g. behind a proxy, they're to the protocol's usual port.
+	if c.CallbackPort == 0 {
+		switch {
+		case c.Tls == (c.CallbackProto == "https"):
+			c.CallbackPort = c.Port
+		case c.CallbackProto == "https":
+			c.CallbackPort = 443
+		default:
+			c.CallbackPort = 80
+		}
+	}
+}
+
+// Validate returns an error describing everything wrong with c, or nil if
+// vator can run with it.
+func (c *Config) Validate() error {
+	var errs []error
+	for name, value := range map[string]string{
+		"consumer-key":    c.ConsumerKey,
+		"consumer-secret": c.ConsumerSecret,
+		"callback-domain": c.CallbackDomain,
+		"db-file":         c.DbFile,
+	} {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s must be provided", name))
+		}
+	}
+
+	for name, port := range map[string]int{"port": c.Port, "callback-port": c.CallbackPort} {
+		if port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s must be between 1 and 65535, not %d", name, port))
+		}
+	}
+
+	if c.CallbackProto != "http" && c.CallbackProto != "https" {
+		errs = append(errs, fmt.Errorf("callback-proto must be http or https, not %q", c.CallbackProto))
+	}
+
+	if (c.TwilioSid == "") != (c.TwilioToken == "") {
+		errs = append(errs, errors.New("twilio-sid and twilio-token must be provided together"))
+	}
+
+	for name, value := range map[string]string{"twilio-api": c.TwilioApi, "telegram-api": c.TelegramApi} {
+		if u, err := url.Parse(value); value != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+			errs = append(errs, fmt.Errorf("%s must be an absolute URL, not %q", name, value))
+		}
+	}
+
+	// Shorter secrets wouldn't be redacted from logs.
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if value := v.Field(i).String(); field.Tag.Get("secret") == "true" && value != "" && len(value) < log.MinSecret {
+			errs = append(errs, fmt.Errorf("%s must be at least %d characters", field.Tag.Get("toml"), log.MinSecret))
+		}
+	}
+
+	if c.DrainTimeout.Duration < 0 {
+		errs = append(errs, fmt.Errorf("drain-timeout must not be negative, not %s", c.DrainTimeout))
+	}
+
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log-format must be text or json, not %q", c.LogFormat))
+	}
+	if _, _, err := log.ParseLevels(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log-level: %w", err))
+	}
+
+	// Maps are unordered, so the errors are sorted for a stable message.
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+// Redact returns a copy of c with every secret that's set replaced by
+// Redacted.
+func (c Config) Redact() Config {
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("secret") == "true" && v.Field(i).String() != "" {
+			v.Field(i).SetString(Redacted)
+		}
+	}
+	return c
+}
+
+// Secrets returns the secrets that are set, to be redacted from logs.
+func (c *Config) Secrets() []string {
+	var ret []string
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("secret") == "true" && v.Field(i).String() != "" {
+			ret = append(ret, v.Field(i).String())
+		}
+	}
+	return ret
+}
+
+// Print writes c to w as a TOML configuration file, with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(c.Redact())
+}
+
+// Duration is a time.Duration that's written as e.g. "8s" in configuration
+// files and flags.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+// Set makes *Duration a flag.Value.
+func (d *Duration) Set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
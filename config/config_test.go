@@ -0,0 +1,234 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets every variable configuration is read from, for the length
+// of the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv(FileEnv, "")
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		t.Setenv(typ.Field(i).Tag.Get("env"), "")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParsePrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "vator.toml", `
+consumer-key = "from-file"
+consumer-secret = "from-file"
+db-file = "/file/vator.db"
+port = 8080
+drain-timeout = "20s"
+`)
+	t.Setenv("VATOR_DB_FILE", "/env/vator.db")
+	t.Setenv("VATOR_PORT", "9090")
+	t.Setenv("VATOR_TLS", "true")
+	// Set by hosting platforms for themselves, not for vator.
+	t.Setenv("PORT", "5000")
+
+	fs := flag.NewFlagSet("vator", flag.ContinueOnError)
+	c, err := Parse(fs, []string{"-config", path, "-port", "7070", "config", "print"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Default()
+	want.ConsumerKey = "from-file"
+	want.ConsumerSecret = "from-file"
+	want.DbFile = "/env/vator.db"
+	want.Port = 7070
+	want.CallbackPort = 80 // TLS, but http callbacks
+	want.Tls = true
+	want.DrainTimeout = Duration{20 * time.Second}
+	if *c != want {
+		t.Errorf("got %+v\nwant %+v", *c, want)
+	}
+	if args := fs.Args(); !reflect.DeepEqual(args, []string{"config", "print"}) {
+		t.Errorf("got args %q, want the command", args)
+	}
+}
+
+func TestParseFlagEqualToDefault(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("VATOR_DRY_RUN", "true")
+
+	// Given explicitly, a flag overrides the environment even when it's the
+	// default.
+	c, err := Parse(flag.NewFlagSet("vator", flag.ContinueOnError), []string{"-dry-run=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DryRun {
+		t.Error("-dry-run=false did not override $VATOR_DRY_RUN")
+	}
+}
+
+func TestLoadYaml(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "vator.yaml", `
+consumer-key: key
+callback-domain: vator.example.com
+tls: true
+drain-timeout: 1m
+`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ConsumerKey != "key" || c.CallbackDomain != "vator.example.com" || !c.Tls || c.DrainTimeout.Duration != time.Minute {
+		t.Errorf("got %+v", *c)
+	}
+	if c.Port != 443 || c.CallbackPort != 80 {
+		t.Errorf("with TLS and http callbacks, got port %d and callback port %d, want 443 and 80", c.Port, c.CallbackPort)
+	}
+}
+
+func TestCallbackPort(t *testing.T) {
+	clearEnv(t)
+	for _, test := range []struct {
+		args []string
+		want int
+	}{
+		{args: []string{"-port", "8080"}, want: 8080},
+		{args: []string{"-tls"}, want: 80},
+		{args: []string{"-tls", "-callback-proto", "https"}, want: 443},
+		{args: []string{"-tls", "-port", "8443", "-callback-proto", "https"}, want: 8443},
+		{args: []string{"-port", "8080", "-callback-proto", "https"}, want: 443},
+		{args: []string{"-tls", "-callback-port", "8000"}, want: 8000},
+	} {
+		c, err := Parse(flag.NewFlagSet("vator", flag.ContinueOnError), test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.CallbackPort != test.want {
+			t.Errorf("%q: got callback port %d, want %d", test.args, c.CallbackPort, test.want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want string
+	}{
+		{name: "unknown toml key", file: writeFile(t, "vator.toml", "db-flie = \"x\"\n"), want: "unknown settings db-flie"},
+		{name: "unknown yaml key", file: writeFile(t, "vator.yml", "db-flie: x\n"), want: "db-flie"},
+		{name: "bad toml", file: writeFile(t, "bad.toml", "port = \n"), want: "bad.toml"},
+		{name: "bad duration", file: writeFile(t, "vator.toml", "drain-timeout = \"soon\"\n"), want: "soon"},
+		{name: "missing file", file: filepath.Join(t.TempDir(), "nope.toml"), want: "nope.toml"},
+		{name: "bad env", env: map[string]string{"VATOR_PORT": "eighty"}, want: `$VATOR_PORT: invalid value "eighty"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(test.file)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one mentioning %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.ConsumerKey = "consumer-key"
+	c.ConsumerSecret = "consumer-secret"
+	c.resolve()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("valid configuration: %v", err)
+	}
+
+	c = Default()
+	c.ConsumerKey = "consumer-key"
+	c.MetricsToken = "s3cr3t"
+	c.Port = 70000
+	c.CallbackPort = 80
+	c.CallbackProto = "ftp"
+	c.TwilioSid = "AC123"
+	c.TelegramApi = "api.telegram.org"
+	c.DrainTimeout = Duration{-time.Second}
+	c.LogFormat = "xml"
+	c.LogLevel = "info,scanner=loud"
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	for _, want := range []string{
+		"consumer-secret must be provided",
+		"port must be between 1 and 65535, not 70000",
+		`callback-proto must be http or https, not "ftp"`,
+		"twilio-sid and twilio-token must be provided together",
+		`telegram-api must be an absolute URL, not "api.telegram.org"`,
+		"drain-timeout must not be negative",
+		`log-format must be text or json, not "xml"`,
+		`log-level: unknown log level "loud"`,
+		"metrics-token must be at least 8 characters",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "consumer-key") {
+		t.Errorf("error %q mentions consumer-key, which was given", err)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	c := Default()
+	c.ConsumerKey = "hunter2"
+	c.ConsumerSecret = "hunter3"
+	c.TwilioSid = "AC123"
+	c.resolve()
+
+	var out bytes.Buffer
+	if err := c.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+	for _, secret := range []string{"hunter2", "hunter3"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("printed configuration contains %q:\n%s", secret, printed)
+		}
+	}
+	if c.ConsumerKey != "hunter2" {
+		t.Error("printing redacted the configuration itself")
+	}
+
+	// What's printed reads back as the same configuration, but for secrets.
+	clearEnv(t)
+	read, err := Load(writeFile(t, "printed.toml", printed))
+	if err != nil {
+		t.Fatalf("reading printed configuration: %v\n%s", err, printed)
+	}
+	if *read != c.Redact() {
+		t.Errorf("read back %+v\nwant %+v", *read, c.Redact())
+	}
+	if read.ConsumerKey != Redacted || read.TwilioToken != "" || read.TwilioSid != "AC123" {
+		t.Errorf("got consumer key %q, twilio token %q and sid %q", read.ConsumerKey, read.TwilioToken, read.TwilioSid)
+	}
+}
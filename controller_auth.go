@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -15,34 +16,63 @@ import (
 )
 
 func Bail(rw http.ResponseWriter, req *http.Request, err error, status int) {
-	Log.ExtraCalldepth++
-	Log.Errorf("%s: %s", req.RemoteAddr, err)
-	Log.ExtraCalldepth--
-	http.Error(rw, "very sorry! afraid something went wrong...", status)
-	return
+	reqLog(req).CallerSkip(1).With("remote", req.RemoteAddr, "status", status).Error(err)
+	Sorry(rw, req, status)
 }
 
-func RequireAuth(db *bbolt.DB, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
-	return func(rw http.ResponseWriter, req *http.Request) {
-		user, err := models.SessionGet(db, req, "user")
-		if err != nil {
-			rw.Header().Add("location", "/login")
-			http.Error(rw, "You are not logged in.", http.StatusFound)
-			return
-		}
-		handler(rw, req.WithContext(context.WithValue(req.Context(), "user", user)))
+// Sorry responds with status and the friendly error page, which gives the
+// request's ID for the user to pass along.
+func Sorry(rw http.ResponseWriter, req *http.Request, status int) {
+	// The user's shown in the navbar, if RequireAuth got as far as finding
+	// them.
+	user, _ := req.Context().Value("user").(string)
+	var page bytes.Buffer
+	err := templates.ExecuteTemplate(&page, "sorry.tmpl", TemplateContext{
+		User:      user,
+		RequestId: rw.Header().Get(RequestIdHeader),
+	})
+	if err != nil {
+		reqLog(req).Errorf("error rendering template: %v", err)
+		http.Error(rw, "very sorry! afraid something went wrong...", status)
+		return
 	}
+	rw.Header().Set("content-type", "text/html; charset=utf-8")
+	rw.Header().Set("x-content-type-options", "nosniff")
+	rw.WriteHeader(status)
+	page.WriteTo(rw)
 }
 
-func RequireNotAuth(db *bbolt.DB, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
-	return func(rw http.ResponseWriter, req *http.Request) {
-		_, err := models.SessionGet(db, req, "user")
-		if err == nil {
-			rw.Header().Add("location", "/")
-			http.Error(rw, "You are logged in!", http.StatusFound)
-			return
-		}
-		handler(rw, req)
+// RequireAuth sends anyone not logged in to /login, and adds the user to the
+// request's context and logger for those who are.
+func RequireAuth(db *bbolt.DB) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			user, err := models.SessionGet(db, req, "user")
+			if err != nil {
+				rw.Header().Add("location", "/login")
+				http.Error(rw, "You are not logged in.", http.StatusFound)
+				return
+			}
+			setAccessUser(req, user)
+			ctx := context.WithValue(req.Context(), "user", user)
+			ctx = WithContext(ctx, reqLog(req).With("user", user))
+			handler.ServeHTTP(rw, req.WithContext(ctx))
+		})
+	}
+}
+
+// RequireNotAuth sends anyone already logged in home.
+func RequireNotAuth(db *bbolt.DB) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, err := models.SessionGet(db, req, "user")
+			if err == nil {
+				rw.Header().Add("location", "/")
+				http.Error(rw, "You are logged in!", http.StatusFound)
+				return
+			}
+			handler.ServeHTTP(rw, req)
+		})
 	}
 }
 
@@ -65,15 +95,8 @@ func notifications(db *bbolt.DB, req *http.Request) (TemplateContext, error) {
 	return ctx, nil
 }
 
-func LoginHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
-	return func(rw http.ResponseWriter, req *http.Request) {
-		switch req.Method {
-		case "POST":
-			RequireForm([]string{"username", "password"}, LoginHandlerPost(db))(rw, req)
-		default:
-			TemplateGet(rw, req, "login.tmpl", TemplateContext{Page: "login"})
-		}
-	}
+func LoginHandlerGet(rw http.ResponseWriter, req *http.Request) {
+	TemplateGet(rw, req, "login.tmpl", TemplateContext{Page: "login"})
 }
 
 func LoginHandlerPost(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
@@ -102,10 +125,10 @@ func LoginHandlerPost(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func TemplateGet(rw http.ResponseWriter, _ *http.Request, template string, ctx TemplateContext) {
+func TemplateGet(rw http.ResponseWriter, req *http.Request, template string, ctx TemplateContext) {
 	err := templates.ExecuteTemplate(rw, template, ctx)
 	if err != nil {
-		Log.Errorf("error rendering template: %v", err)
+		reqLog(req).Errorf("error rendering template: %v", err)
 		http.Error(rw, "Very sorry; something went wrong.", http.StatusInternalServerError)
 		return
 	}
@@ -115,7 +138,7 @@ func LogoutHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		http.SetCookie(rw, &http.Cookie{Name: "session", Expires: time.Unix(0, 0)})
 		if err := models.SessionDeleteReq(db, req); err != nil {
-			Log.Errorf("error rendering template: %v", err)
+			reqLog(req).Errorf("deleting session: %v", err)
 			http.Error(rw, "Very sorry; something went wrong.", http.StatusInternalServerError)
 			return
 		}
@@ -124,32 +147,70 @@ func LogoutHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func PhoneHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+// PhoneHandlerPost begins verification of a new phone number. The number is
+// not used for anything else until PhoneVerifyHandler confirms it.
+func PhoneHandlerPost(db *bbolt.DB, twilio *models.Twilio) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		switch req.Method {
-		case "POST":
-			RequireForm([]string{"phone"}, PhoneHandlerPost(db))(rw, req)
-		default:
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("should be logged in, but: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		phone, err := models.NormalizePhone(req.FormValue("phone"))
+		if err == nil && phone == user.Phone && user.PhoneVerified {
+			err = models.SessionSet(db, req, "toast", "that's already your phone number!")
+			if err != nil {
+				Bail(rw, req, fmt.Errorf("setting toast msg in session: %s", err), http.StatusInternalServerError)
+				return
+			}
 			http.Redirect(rw, req, "/", http.StatusFound)
+			return
+		}
+
+		if err == nil {
+			err = models.StartPhoneVerification(db, twilio, user.Username, phone)
 		}
+
+		key, msg := "toast", "we texted you a code; enter it below to finish!"
+		if err != nil {
+			reqLog(req).Warningf("starting phone verification for %q: %s", user.Username, err)
+			key, msg = "error", err.Error()
+			if !errors.Is(err, models.InvalidPhone) && !errors.Is(err, models.PhoneRateLimited) {
+				msg = "we couldn't text that number; double-check it and try again?"
+			}
+		}
+		if err := models.SessionSet(db, req, key, msg); err != nil {
+			Bail(rw, req, fmt.Errorf("setting %s msg in session: %s", key, err), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/", http.StatusFound)
 	}
 }
 
-func PhoneHandlerPost(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+func PhoneVerifyHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		user, err := models.LoadUserRequest(db, req)
 		if err != nil {
 			Bail(rw, req, fmt.Errorf("should be logged in, but: %s", err), http.StatusInternalServerError)
 			return
 		}
-		user.Phone = req.FormValue("phone")
-		if err := user.Save(db); err != nil {
-			Bail(rw, req, fmt.Errorf("saving user %q: %s", user.Username, err), http.StatusInternalServerError)
+
+		_, saveErr := models.UpdateUser(db, user.Username, func(u *models.User) error {
+			err = u.VerifyPhone(req.Form.Get("code"))
+			return nil
+		})
+		if saveErr != nil {
+			Bail(rw, req, fmt.Errorf("saving user %q: %s", user.Username, saveErr), http.StatusInternalServerError)
 			return
 		}
-		err = models.SessionSet(db, req, "toast", "phone number updated!")
+
+		key, msg := "toast", "phone number verified!"
 		if err != nil {
-			Bail(rw, req, fmt.Errorf("setting toast msg in session: %s", err), http.StatusInternalServerError)
+			key, msg = "error", err.Error()
+		}
+		if err := models.SessionSet(db, req, key, msg); err != nil {
+			Bail(rw, req, fmt.Errorf("setting %s msg in session: %s", key, err), http.StatusInternalServerError)
 			return
 		}
 		http.Redirect(rw, req, "/", http.StatusFound)
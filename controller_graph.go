@@ -8,18 +8,18 @@ import (
 	"strings"
 	"time"
 
-	"github.com/asymmetricia/vator/log"
 	"github.com/asymmetricia/vator/models"
 	"go.etcd.io/bbolt"
 )
 
 func Graph(db *bbolt.DB) func(rw http.ResponseWriter, req *http.Request) {
-	return RequireForm([]string{"user"}, func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
 		TemplateGet(rw, req, "graph.tmpl", TemplateContext{
-			Page: "graph",
-			User: req.Form.Get("user"),
+			Page:  "graph",
+			User:  req.Form.Get("user"),
+			Embed: req.Form.Get("embed") == "true",
 		})
-	})
+	}
 }
 
 type DataPoint struct {
@@ -29,7 +29,7 @@ type DataPoint struct {
 }
 
 func Data(db *bbolt.DB) func(rw http.ResponseWriter, req *http.Request) {
-	return RequireForm([]string{"user"}, func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Add("content-type", "application/json")
 		days := 365
 		var err error
@@ -55,13 +55,13 @@ func Data(db *bbolt.DB) func(rw http.ResponseWriter, req *http.Request) {
 		}
 
 		if err != nil {
-			log.Log.Warningf("getting data for user=%q days=%q: %v",
+			reqLog(req).Warningf("getting data for user=%q days=%q: %v",
 				req.Form.Get("user"), req.Form.Get("days"), err)
 			fmt.Fprint(rw, "[]")
 			return
 		}
 
-		log.Log.Debugf("user %q has %d weights", user.Username, len(user.Weights))
+		reqLog(req).Debugf("user %q has %d weights", user.Username, len(user.Weights))
 
 		var start, first time.Time
 		if days > 0 {
@@ -91,7 +91,7 @@ func Data(db *bbolt.DB) func(rw http.ResponseWriter, req *http.Request) {
 			}
 		}
 
-		log.Log.Debugf("%d days to compute requested range", len(series))
+		reqLog(req).Debugf("%d days to compute requested range", len(series))
 
 		if start.IsZero() {
 			start = first
@@ -126,14 +126,14 @@ func Data(db *bbolt.DB) func(rw http.ResponseWriter, req *http.Request) {
 			})
 		}
 
-		log.Log.Debugf("returning %d days", len(ret))
+		reqLog(req).Debugf("returning %d days", len(ret))
 
 		enc := json.NewEncoder(rw)
 		if err := enc.Encode(ret); err != nil {
-			log.Log.Warningf("getting data for user=%q days=%q: %v",
+			reqLog(req).Warningf("getting data for user=%q days=%q: %v",
 				req.Form.Get("user"), req.Form.Get("days"), err)
 		}
-	})
+	}
 }
 
 func MovingAverage(t time.Time, data map[time.Time]*DataPoint, days int) float64 {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+// HistoryHandler shows the messages vator has sent the current user, newest
+// first. Add `format=json` for a machine-readable version.
+func HistoryHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, err, http.StatusInternalServerError)
+			return
+		}
+
+		msgs, err := models.ListOutbox(db, func(m *models.OutboundMessage) bool {
+			return m.Username == user.Username
+		})
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("listing history for %q: %s", user.Username, err), http.StatusInternalServerError)
+			return
+		}
+		sort.Slice(msgs, func(i, j int) bool { return msgs[i].Created.After(msgs[j].Created) })
+
+		if req.URL.Query().Get("format") == "json" {
+			rw.Header().Add("content-type", "application/json")
+			if msgs == nil {
+				msgs = []*models.OutboundMessage{}
+			}
+			if err := json.NewEncoder(rw).Encode(msgs); err != nil {
+				reqLog(req).Warningf("writing history for %q: %v", user.Username, err)
+			}
+			return
+		}
+
+		TemplateGet(rw, req, "history.tmpl", TemplateContext{
+			Page:     "history",
+			User:     user.Username,
+			History:  msgs,
+			Timezone: user.Timezone(),
+		})
+	}
+}
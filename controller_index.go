@@ -3,13 +3,13 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/asymmetricia/vator/models"
-	"github.com/asymmetricia/withings"
 	"go.etcd.io/bbolt"
 )
 
-func IndexHandler(db *bbolt.DB, withings *withings.Client) func(http.ResponseWriter, *http.Request) {
+func IndexHandler(db *bbolt.DB, withings *models.Withings, telegram *models.Telegram) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		user, err := models.LoadUserRequest(db, req)
 		if err != nil {
@@ -18,15 +18,65 @@ func IndexHandler(db *bbolt.DB, withings *withings.Client) func(http.ResponseWri
 			return
 		}
 
+		if telegram != nil && user.TelegramChatId == 0 && user.TelegramCode == "" {
+			updated, err := models.UpdateUser(db, user.Username, func(u *models.User) error {
+				if u.TelegramChatId != 0 || u.TelegramCode != "" {
+					return nil
+				}
+				var err error
+				u.TelegramCode, err = models.NewTelegramCode()
+				return err
+			})
+			if err != nil {
+				Bail(rw, req, fmt.Errorf("generating telegram code for %q: %s", user.Username, err), http.StatusInternalServerError)
+				return
+			}
+			user = updated
+		}
+
 		ctx, err := notifications(db, req)
 		if err != nil {
 			Bail(rw, req, err, http.StatusInternalServerError)
 			return
 		}
 		ctx.Phone = user.Phone
+		ctx.PhoneVerified = user.PhoneVerified
+		ctx.PendingPhone = user.PendingPhone
+		ctx.SmsOptedOut = !user.SmsAllowed()
+		ctx.SmsConsentLog = user.ConsentLog
 		ctx.Kgs = user.Kgs
 		ctx.Share = user.Share
 		ctx.Withings = user.RefreshSecret != ""
+		ctx.TimezoneName = user.Timezone().String()
+		ctx.QuietStart = user.QuietStart
+		ctx.QuietEnd = user.QuietEnd
+		ctx.DailyCap = user.DailyCap
+		ctx.ReminderDays = user.ReminderDays
+		if user.MilestoneStartKgs > 0 {
+			ctx.MilestoneStart = user.FormatKg(user.MilestoneStartKgs)
+		}
+		if user.HeightCm > 0 && user.Kgs {
+			ctx.Height = fmt.Sprintf("%.0f", user.HeightCm)
+		} else if user.HeightCm > 0 {
+			ctx.Height = fmt.Sprintf("%.0f", user.HeightCm/2.54)
+		}
+		ctx.Milestones = user.Milestones
+		for hour := 0; hour < 24; hour++ {
+			ctx.Hours = append(ctx.Hours, hour)
+		}
+		ctx.SummaryCadence = user.SummaryCadence.String()
+		ctx.SummaryCadences = models.SummaryCadences
+		ctx.SummaryDay = user.SummaryDay
+		ctx.SummaryHour = user.SummaryHour
+		ctx.Reports = !user.NoReports
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			ctx.Weekdays = append(ctx.Weekdays, day)
+		}
+		if telegram != nil {
+			ctx.TelegramBot = telegram.Username
+			ctx.TelegramCode = user.TelegramCode
+			ctx.TelegramLinked = user.TelegramChatId != 0
+		}
 
 		ctx.Page = "index"
 		ctx.User = user.Username
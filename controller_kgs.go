@@ -20,8 +20,11 @@ func KgsHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		user.Kgs = !user.Kgs
-		if err := user.Save(db); err != nil {
+		_, err = models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.Kgs = !u.Kgs
+			return nil
+		})
+		if err != nil {
 			Bail(rw, req, err, http.StatusInternalServerError)
 			return
 		}
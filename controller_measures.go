@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
 	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/metrics"
 	"github.com/asymmetricia/vator/models"
-	"github.com/asymmetricia/withings"
 	"go.etcd.io/bbolt"
 )
 
@@ -25,70 +26,105 @@ func MeasuresHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
 				continue
 			}
 			if _, err := fmt.Fprintln(rw, w.Date, " ", u.FormatKg(w.Kgs)); err != nil {
-				Log.Errorf("writing output to user: %s", err)
+				reqLog(req).Errorf("writing output to user: %s", err)
 				return
 			}
 		}
 	}
 }
 
+// scannerLog is the logger for the scanner subsystem, which imports weights
+// from withings.
+var scannerLog = New("scanner")
+
 var minBackfill = time.Date(2008, time.January, 0, 0, 0, 0, 0, time.UTC)
 
-func BackfillMeasures(db *bbolt.DB, withings *withings.Client) {
+func BackfillMeasures(db *bbolt.DB, withings *models.Withings) {
+	defer observeScan("backfill", time.Now())
 	for _, u := range models.GetUsers(db) {
 		if u.BackFillDate.IsZero() {
-			Log.Debugf("initializing backfill for %q", u.Username)
+			scannerLog.Debugf("initializing backfill for %q", u.Username)
 			u.BackFillDate = time.Now()
 		}
 
 		if u.BackFillDate.Before(minBackfill) {
-			Log.Debugf("backfill complete for %q -> %s", u.Username, u.BackFillDate)
+			scannerLog.Debugf("backfill complete for %q -> %s", u.Username, u.BackFillDate)
 			continue
 		}
 
-		before := len(u.Weights)
 		bfFrom := u.BackFillDate.Add(-365 * 24 * time.Hour)
 		bfTo := u.BackFillDate
 
-		err := u.GetWeights(db, withings, bfFrom, bfTo)
+		added, err := u.GetWeights(db, withings, bfFrom, bfTo)
 
 		if err == nil {
-			u.BackFillDate = bfFrom
-			err = u.Save(db)
+			_, err = models.UpdateUser(db, u.Username, func(u *models.User) error {
+				u.BackFillDate = bfFrom
+				return nil
+			})
 		}
 
 		if err != nil {
-			Log.Warningf("error backfilling weights for %q: %s", u.Username, err)
+			scannerLog.Warningf("error backfilling weights for %q: %s", u.Username, err)
 			return
 		}
 
-		if before != len(u.Weights) {
-			Log.Debugf("fetched %d old weights for %q", len(u.Weights)-before,
-				u.Username)
+		if added > 0 {
+			scannerLog.Debugf("fetched %d old weights for %q", added, u.Username)
+			metrics.WeightsImported.WithLabelValues("backfill").Add(float64(added))
 		}
 	}
 }
 
-func ScanMeasures(db *bbolt.DB, withings *withings.Client, twilio *models.Twilio) {
+// ScanLoop scans for new weights every minute, and backfills older ones half
+// a minute after each scan, until ctx is done. tick is called after each scan
+// and backfill, to show the loop is still going.
+func ScanLoop(ctx context.Context, db *bbolt.DB, withings *models.Withings, notifier *models.Notifier, tick func()) {
+	minutely := time.NewTicker(time.Minute)
+	defer minutely.Stop()
+	for {
+		ScanMeasures(db, withings, notifier)
+		tick()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+
+		BackfillMeasures(db, withings)
+		tick()
+		select {
+		case <-ctx.Done():
+			return
+		case <-minutely.C:
+		}
+	}
+}
+
+func ScanMeasures(db *bbolt.DB, withings *models.Withings, notifier *models.Notifier) {
+	defer observeScan("scan", time.Now())
 	for _, u := range models.GetUsers(db) {
 		if u.LastWeight.IsZero() {
 			u.LastWeight = time.Now().AddDate(0, 0, -37)
 		}
 
-		before := len(u.Weights)
-		err := u.GetWeights(db, withings, u.LastWeight.Add(time.Minute), time.Now())
+		added, err := u.GetWeights(db, withings, u.LastWeight.Add(time.Minute), time.Now())
 		if err != nil {
-			Log.Warningf("error getting weights for %q: %s", u.Username, err)
+			scannerLog.Warningf("error getting weights for %q: %s", u.Username, err)
 			continue
 		}
 
-		if len(u.Weights) != before {
-			Log.Debugf("%q: %d weights before update, %d after; sending toast",
-				u.Username, before, len(u.Weights))
-			go u.Toast(twilio)
+		if added > 0 {
+			scannerLog.Debugf("%q: %d new weights; sending toast", u.Username, added)
+			metrics.WeightsImported.WithLabelValues("scan").Add(float64(added))
+			notifier.Go(func() { u.Toast(notifier) })
 		} else {
-			Log.Debugf("no new weights for %q", u.Username)
+			scannerLog.Debugf("no new weights for %q", u.Username)
 		}
-		go u.Summary(twilio, db, false)
 	}
 }
+
+// observeScan records how long a scan or backfill that began at start took.
+func observeScan(kind string, start time.Time) {
+	metrics.ScanDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asymmetricia/vator/metrics"
+	"github.com/asymmetricia/vator/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.etcd.io/bbolt"
+)
+
+func TestScanMeasures(t *testing.T) {
+	fake := newFakeWithings(t)
+	db := testDb(t)
+	client := fake.Client()
+
+	notifier := models.NewNotifier(db, nil, nil)
+	notifier.DryRun = true
+
+	now := time.Now()
+	for i := 9; i >= 1; i-- {
+		fake.AddWeight(now.AddDate(0, 0, -i), 80+float64(i)/10)
+	}
+	// Older than the first scan looks back.
+	fake.AddWeight(now.AddDate(0, 0, -60), 90)
+
+	access, refresh := fake.Link()
+	user := &models.User{Username: "scanner", AccessToken: access, RefreshSecret: refresh, TokenExpiry: now.Add(time.Hour)}
+	if err := user.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := testutil.ToFloat64(metrics.WeightsImported.WithLabelValues("scan"))
+	requested := testutil.ToFloat64(metrics.WithingsRequests.WithLabelValues("getmeas"))
+	ScanMeasures(db, client, notifier)
+	user = toasted(t, db, notifier, "scanner", 1)
+	if n := testutil.ToFloat64(metrics.WeightsImported.WithLabelValues("scan")) - imported; n != 9 {
+		t.Errorf("counted %v weights imported, want 9", n)
+	}
+	if n := testutil.ToFloat64(metrics.WithingsRequests.WithLabelValues("getmeas")) - requested; n != float64(fake.Getmeas()) {
+		t.Errorf("counted %v getmeas requests, want %d", n, fake.Getmeas())
+	}
+	if len(user.Weights) != 9 {
+		t.Errorf("after the first scan, got %d weights, want 9", len(user.Weights))
+	}
+	if want := now.AddDate(0, 0, -1).Truncate(time.Second); !user.LastWeight.Equal(want) {
+		t.Errorf("after the first scan, last weight is %s, want %s", user.LastWeight, want)
+	}
+
+	// Nothing new, so no toast.
+	ScanMeasures(db, client, notifier)
+	toasted(t, db, notifier, "scanner", 1)
+
+	fake.AddWeight(now.Add(-time.Minute), 79.5)
+	ScanMeasures(db, client, notifier)
+	user = toasted(t, db, notifier, "scanner", 2)
+	if len(user.Weights) != 10 {
+		t.Errorf("after a new weigh-in, got %d weights, want 10", len(user.Weights))
+	}
+	if n := fake.Getmeas(); n != 3 {
+		t.Errorf("got %d getmeas requests, want 3", n)
+	}
+}
+
+func TestBackfillMeasures(t *testing.T) {
+	fake := newFakeWithings(t)
+	db := testDb(t)
+	client := fake.Client()
+
+	now := time.Now()
+	dates := []time.Time{
+		now.AddDate(0, 0, -1),
+		now.AddDate(0, -6, 0),
+		now.AddDate(-2, 0, 0),
+		now.AddDate(-10, 0, 0),
+		minBackfill.AddDate(0, 1, 0),
+	}
+	for i, date := range dates {
+		fake.AddWeight(date, 100-float64(i))
+	}
+
+	access, refresh := fake.Link()
+	user := &models.User{Username: "backfiller", AccessToken: access, RefreshSecret: refresh, TokenExpiry: now.Add(time.Hour)}
+	if err := user.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// A year at a time, until it reaches withings' beginning.
+	years := now.Year() - minBackfill.Year() + 1
+	for i := 0; i < years+2; i++ {
+		BackfillMeasures(db, client)
+	}
+
+	user, err := models.LoadUser(db, "backfiller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.BackFillDate.Before(minBackfill) {
+		t.Errorf("backfill date is %s, want before %s", user.BackFillDate, minBackfill)
+	}
+	if len(user.Weights) != len(dates) {
+		t.Fatalf("got %d weights, want %d: %v", len(user.Weights), len(dates), user.Weights)
+	}
+	for i, w := range user.Weights {
+		want := dates[len(dates)-1-i].Truncate(time.Second)
+		if !w.Date.Equal(want) {
+			t.Errorf("weight %d is from %s, want %s", i, w.Date, want)
+		}
+	}
+
+	// Once complete, backfill leaves withings alone.
+	before := fake.Getmeas()
+	BackfillMeasures(db, client)
+	if n := fake.Getmeas(); n != before {
+		t.Errorf("after backfill completed, got %d more getmeas requests", n-before)
+	}
+}
+
+// TestConcurrentWriters runs the scanner, backfill, a summary and a settings
+// change at once, as main does, and checks that none loses another's changes.
+func TestConcurrentWriters(t *testing.T) {
+	fake := newFakeWithings(t)
+	db := testDb(t)
+	client := fake.Client()
+
+	notifier := models.NewNotifier(db, nil, nil)
+	notifier.DryRun = true
+
+	now := time.Now()
+	for i := 9; i >= 1; i-- {
+		fake.AddWeight(now.AddDate(0, 0, -i), 80+float64(i)/10)
+	}
+	fake.AddWeight(now.AddDate(0, -6, 0), 90)
+
+	// Backfill is under way, so it and the scanner fetch different weights.
+	backfilled := now.AddDate(0, 0, -40)
+	access, refresh := fake.Link()
+	user := &models.User{Username: "busy", AccessToken: access, RefreshSecret: refresh, TokenExpiry: now.Add(time.Hour),
+		BackFillDate: backfilled}
+	if err := user.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, run := range []func(){
+		func() { ScanMeasures(db, client, notifier) },
+		func() { BackfillMeasures(db, client) },
+		func() { user.Summary(notifier, db, true) },
+		func() {
+			_, err := models.UpdateUser(db, "busy", func(u *models.User) error {
+				u.ReminderDays = 3
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	} {
+		wg.Add(1)
+		go func(run func()) {
+			defer wg.Done()
+			run()
+		}(run)
+	}
+	wg.Wait()
+
+	user = toasted(t, db, notifier, "busy", 1)
+	if len(user.Weights) != 10 {
+		t.Errorf("got %d weights, want all 10 from scanning and backfill", len(user.Weights))
+	}
+	if user.LastSummary.IsZero() {
+		t.Error("the summary was not recorded")
+	}
+	if user.ReminderDays != 3 {
+		t.Errorf("got reminder days %d, want 3", user.ReminderDays)
+	}
+	if want := backfilled.Add(-365 * 24 * time.Hour); !user.BackFillDate.Equal(want) {
+		t.Errorf("backfill date is %s, want %s", user.BackFillDate, want)
+	}
+	if user.RefreshSecret != refresh {
+		t.Errorf("got refresh token %q, want %q", user.RefreshSecret, refresh)
+	}
+}
+
+// toasts counts the dry-run toasts stored in the outbox.
+func toasts(t *testing.T, db *bbolt.DB) int {
+	t.Helper()
+	msgs, err := models.ListOutbox(db, func(m *models.OutboundMessage) bool { return m.Kind == "toast" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(msgs)
+}
+
+// toasted waits for the toasts ScanMeasures started, checks that there have
+// been want in all, and returns the user as saved.
+func toasted(t *testing.T, db *bbolt.DB, notifier *models.Notifier, username string, want int) *models.User {
+	t.Helper()
+	notifier.Wait()
+	if n := toasts(t, db); n != want {
+		t.Errorf("got %d toasts, want %d", n, want)
+	}
+	user, err := models.LoadUser(db, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestScanLoopStops(t *testing.T) {
+	fake := newFakeWithings(t)
+	db := testDb(t)
+
+	notifier := models.NewNotifier(db, nil, nil)
+	notifier.DryRun = true
+
+	now := time.Now()
+	for i := 5; i >= 1; i-- {
+		fake.AddWeight(now.AddDate(0, 0, -i), 80+float64(i)/10)
+	}
+	access, refresh := fake.Link()
+	user := &models.User{Username: "stopper", AccessToken: access, RefreshSecret: refresh, TokenExpiry: now.Add(time.Hour)}
+	if err := user.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Already cancelled, so it scans once and returns without waiting to
+	// backfill.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		ScanLoop(ctx, db, fake.Client(), notifier, func() {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ScanLoop did not return once cancelled")
+	}
+
+	user = toasted(t, db, notifier, "stopper", 1)
+	if len(user.Weights) != 5 {
+		t.Errorf("got %d weights, want 5", len(user.Weights))
+	}
+	if !user.BackFillDate.IsZero() {
+		t.Errorf("backfilled to %s after being cancelled", user.BackFillDate)
+	}
+}
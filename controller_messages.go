@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+// MessagesHandler previews every message the current user might be sent, in
+// their chosen language and tone, and lets them choose another.
+func MessagesHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx, err := notifications(db, req)
+		if err != nil {
+			Bail(rw, req, err, http.StatusInternalServerError)
+			return
+		}
+		ctx.Page = "messages"
+		ctx.User = user.Username
+		ctx.Language = user.Locale()
+		ctx.Languages = models.Messages.Locales()
+		ctx.Tone = user.MessageTone()
+		ctx.Tones = models.Tones
+		ctx.Previews = user.PreviewMessages(time.Now())
+		TemplateGet(rw, req, "messages.tmpl", ctx)
+	}
+}
+
+// MessagesHandlerPost saves the user's language and tone.
+func MessagesHandlerPost(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("should be logged in, but: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		language, tone := req.Form.Get("language"), req.Form.Get("tone")
+		if !contains(models.Messages.Locales(), language) || !contains(models.Tones, tone) {
+			if err := models.SessionSet(db, req, "error", "pick a language and tone from the list"); err != nil {
+				Bail(rw, req, fmt.Errorf("setting error msg in session: %s", err), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(rw, req, "/messages", http.StatusFound)
+			return
+		}
+
+		_, err = models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.Language = language
+			u.Tone = tone
+			return nil
+		})
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("saving user %q: %s", user.Username, err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := models.SessionSet(db, req, "toast", "message style saved!"); err != nil {
+			Bail(rw, req, fmt.Errorf("setting toast msg in session: %s", err), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/messages", http.StatusFound)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
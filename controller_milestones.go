@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+// MilestonesHandler saves the weight loss milestones are measured from and the
+// user's height, for BMI. Both are in the user's preferred units; leaving the
+// start blank measures from the user's current trend.
+func MilestonesHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("should be logged in, but: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Both are optional, so blanks are fine.
+		if err := req.ParseForm(); err != nil {
+			Bail(rw, req, err, http.StatusBadRequest)
+			return
+		}
+
+		var start, height float64
+		var problem string
+		if s := strings.TrimSpace(req.Form.Get("start")); s != "" {
+			if start, err = user.ParseWeight(s); err != nil {
+				problem = fmt.Sprintf("couldn't use that starting weight: %s", err)
+			}
+		}
+		if h := strings.TrimSpace(req.Form.Get("height")); h != "" && problem == "" {
+			height, err = strconv.ParseFloat(h, 64)
+			if !user.Kgs {
+				height *= 2.54
+			}
+			if err != nil || height < 50 || height > 275 {
+				problem = fmt.Sprintf("%q doesn't look like a height", h)
+			}
+		}
+		if problem != "" {
+			if err := models.SessionSet(db, req, "error", problem); err != nil {
+				Bail(rw, req, fmt.Errorf("setting error msg in session: %s", err), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(rw, req, "/", http.StatusFound)
+			return
+		}
+
+		_, err = models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.MilestoneStartKgs = start
+			u.HeightCm = height
+			return nil
+		})
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("saving user %q: %s", user.Username, err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := models.SessionSet(db, req, "toast", "milestone settings saved!"); err != nil {
+			Bail(rw, req, fmt.Errorf("setting toast msg in session: %s", err), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/", http.StatusFound)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/asymmetricia/vator/models"
+)
+
+func TestMilestonesHandler(t *testing.T) {
+	db := testDb(t)
+	if err := (&models.User{Username: "milestoner", MilestoneStartKgs: 90, HeightCm: 170}).Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(form url.Values) *models.User {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/milestones", strings.NewReader(form.Encode()))
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		req = asUser(req, "milestoner")
+		req = req.WithContext(context.WithValue(req.Context(), "session", "milestones-session"))
+		rec := httptest.NewRecorder()
+		MilestonesHandler(db)(rec, req)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("posting %v: got status %d %q, want a redirect", form, rec.Code, rec.Body)
+		}
+		user, err := models.LoadUser(db, "milestoner")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	// A start weight without a height...
+	user := post(url.Values{"start": {"200"}, "height": {""}})
+	if user.MilestoneStartKgs < 90.7 || user.MilestoneStartKgs > 90.8 || user.HeightCm != 0 {
+		t.Errorf("got start %v kg, height %v cm; want 200lb and no height", user.MilestoneStartKgs, user.HeightCm)
+	}
+
+	// ... and clearing both, to measure from the current trend.
+	user = post(url.Values{"start": {""}, "height": {""}})
+	if user.MilestoneStartKgs != 0 || user.HeightCm != 0 {
+		t.Errorf("got start %v kg, height %v cm; want both cleared", user.MilestoneStartKgs, user.HeightCm)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+// NotificationsHandler saves the user's time zone, quiet hours, daily message
+// cap and how long before they're reminded to weigh in.
+func NotificationsHandler(db *bbolt.DB, scheduler *Scheduler) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("should be logged in, but: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		timezone := req.Form.Get("timezone")
+		quietStart, startErr := strconv.Atoi(req.Form.Get("quiet_start"))
+		quietEnd, endErr := strconv.Atoi(req.Form.Get("quiet_end"))
+		dailyCap, capErr := strconv.Atoi(req.Form.Get("daily_cap"))
+		reminderDays, reminderErr := strconv.Atoi(req.Form.Get("reminder_days"))
+
+		var problem string
+		if _, err := time.LoadLocation(timezone); err != nil {
+			problem = fmt.Sprintf("%q isn't a time zone I know; try something like America/New_York", timezone)
+		} else if startErr != nil || endErr != nil || quietStart < 0 || quietStart > 23 || quietEnd < 0 || quietEnd > 23 {
+			problem = "quiet hours must be between 0 and 23"
+		} else if capErr != nil || dailyCap < 0 {
+			problem = "the daily limit must be zero (no limit) or more"
+		} else if reminderErr != nil || reminderDays < 0 {
+			problem = "reminders must be after zero (never) or more days"
+		}
+		if problem != "" {
+			if err := models.SessionSet(db, req, "error", problem); err != nil {
+				Bail(rw, req, fmt.Errorf("setting error msg in session: %s", err), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(rw, req, "/", http.StatusFound)
+			return
+		}
+
+		_, err = models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.TimezoneName = timezone
+			u.QuietStart = quietStart
+			u.QuietEnd = quietEnd
+			u.DailyCap = dailyCap
+			u.ReminderDays = reminderDays
+			return nil
+		})
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("saving user %q: %s", user.Username, err), http.StatusInternalServerError)
+			return
+		}
+		scheduler.Reschedule()
+
+		if err := models.SessionSet(db, req, "toast", "notification settings saved!"); err != nil {
+			Bail(rw, req, fmt.Errorf("setting toast msg in session: %s", err), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/", http.StatusFound)
+	}
+}
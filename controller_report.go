@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+// ReportStat is one line of a report as shown on the report page.
+type ReportStat struct {
+	Label string
+	Value string
+}
+
+// ReportHandler shows the current user's monthly or yearly report. The period
+// is chosen with `period=month` (the default) or `period=year`, and `date`
+// picks which one, e.g. `2026-09` or `2026`; it defaults to the current one.
+func ReportHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, err, http.StatusInternalServerError)
+			return
+		}
+
+		period := models.ReportMonth
+		if p := req.URL.Query().Get("period"); p != "" {
+			period, err = models.ParseReportPeriod(p)
+			if err != nil {
+				Bail(rw, req, err, http.StatusBadRequest)
+				return
+			}
+		}
+
+		now := time.Now()
+		at, err := user.ParseReportDate(period, req.URL.Query().Get("date"), now)
+		if err != nil {
+			Bail(rw, req, err, http.StatusBadRequest)
+			return
+		}
+
+		report := user.Report(period, at, now)
+
+		var stats []ReportStat
+		if report.HasTrend {
+			direction := "down"
+			if report.Change() > 0 {
+				direction = "up"
+			}
+			stats = append(stats,
+				ReportStat{"Starting trend", fmt.Sprintf("%s%s on %s", user.FormatKg(report.StartTrend.Kgs), user.Unit(), report.StartTrend.Date.Format("Mon Jan 2"))},
+				ReportStat{"Ending trend", fmt.Sprintf("%s%s on %s", user.FormatKg(report.EndTrend.Kgs), user.Unit(), report.EndTrend.Date.Format("Mon Jan 2"))},
+				ReportStat{"Total change", fmt.Sprintf("%s %s%s", direction, user.FormatKg(math.Abs(report.Change())), user.Unit())})
+		} else {
+			stats = append(stats, ReportStat{"Trend", "insufficient data :("})
+		}
+		if report.WeighInDays > 0 {
+			stats = append(stats,
+				ReportStat{"Lowest day", fmt.Sprintf("%s%s on %s", user.FormatKg(report.Lowest.Kgs), user.Unit(), report.Lowest.Date.Format("Mon Jan 2"))},
+				ReportStat{"Highest day", fmt.Sprintf("%s%s on %s", user.FormatKg(report.Highest.Kgs), user.Unit(), report.Highest.Date.Format("Mon Jan 2"))})
+		}
+		stats = append(stats,
+			ReportStat{"Consistency", fmt.Sprintf("%.0f%%, %d of %d days", report.Consistency(), report.WeighInDays, report.Days)},
+			ReportStat{"Longest streak", fmt.Sprintf("%d days", report.LongestStreak)})
+		if report.HasBestWeek {
+			stats = append(stats, ReportStat{"Best week", fmt.Sprintf("week of %s, down %s%s",
+				report.BestWeek.Format("Mon Jan 2"), user.FormatKg(-report.BestWeekChange), user.Unit())})
+		}
+
+		// The next period starts when this one ends, and is only linked once
+		// it has started.
+		ctx := TemplateContext{
+			Page:        "report",
+			User:        user.Username,
+			Report:      report,
+			ReportStats: stats,
+			ReportPrev:  models.ReportUrl("/", period, period.Add(report.Start, -1)),
+		}
+		if report.End.Before(now) {
+			ctx.ReportNext = models.ReportUrl("/", period, report.End)
+		}
+
+		// The graph always ends today, so show enough of it to cover the
+		// whole period.
+		days := int(math.Ceil(now.Sub(report.Start).Hours() / 24))
+		ctx.GraphUrl = "/graph?" + url.Values{
+			"user":  {user.Username},
+			"days":  {strconv.Itoa(days)},
+			"embed": {"true"},
+		}.Encode()
+
+		TemplateGet(rw, req, "report.tmpl", ctx)
+	}
+}
@@ -20,8 +20,11 @@ func ShareHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		user.Share = !user.Share
-		if err := user.Save(db); err != nil {
+		_, err = models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.Share = !u.Share
+			return nil
+		})
+		if err != nil {
 			Bail(rw, req, err, http.StatusInternalServerError)
 			return
 		}
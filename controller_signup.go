@@ -9,27 +9,18 @@ import (
 	"go.etcd.io/bbolt"
 )
 
-func SignupHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+func SignupHandlerGet(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		switch req.Method {
-		case "POST":
-			RequireForm([]string{"username", "password", "confirm"}, SignupHandlerPost(db))(rw, req)
-		default:
-			SignupHandlerGet(db, rw, req)
+		notif, err := notifications(db, req)
+		if err != nil {
+			Bail(rw, req, err, http.StatusInternalServerError)
+			return
 		}
+		notif.Page = "signup"
+		TemplateGet(rw, req, "signup.tmpl", notif)
 	}
 }
 
-func SignupHandlerGet(db *bbolt.DB, rw http.ResponseWriter, req *http.Request) {
-	notif, err := notifications(db, req)
-	if err != nil {
-		Bail(rw, req, err, http.StatusInternalServerError)
-		return
-	}
-	notif.Page = "signup"
-	TemplateGet(rw, req, "signup.tmpl", notif)
-}
-
 func SignupHandlerPost(db *bbolt.DB) func(w http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		username := strings.ToLower(req.Form.Get("username"))
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+const smsHelp = "vator commands: STATUS, LOG <weight>, SUMMARY, PAUSE, RESUME"
+
+const smsComplianceHelp = "vator: weight trend updates. " + smsHelp +
+	". Reply STOP to unsubscribe, START to resubscribe. Msg & data rates may apply."
+
+// SmsHandler receives Twilio's inbound-message webhook. webhookUrl must be the
+// URL exactly as configured in Twilio, since it is part of the signed payload.
+func SmsHandler(db *bbolt.DB, notifier *models.Notifier, webhookUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if notifier.Twilio == nil {
+			Bail(rw, req, errors.New("inbound SMS received, but twilio is not configured"), http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := req.ParseForm(); err != nil {
+			Bail(rw, req, err, http.StatusBadRequest)
+			return
+		}
+
+		if !notifier.Twilio.ValidSignature(webhookUrl, req.PostForm, req.Header.Get("X-Twilio-Signature")) {
+			Bail(rw, req, fmt.Errorf("invalid twilio signature for %q", webhookUrl), http.StatusForbidden)
+			return
+		}
+
+		// Replies are sent out-of-band, so the TwiML response is always empty.
+		rw.Header().Set("content-type", "text/xml")
+		fmt.Fprint(rw, `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`)
+
+		// Only a verified number can run commands as its user.
+		from := req.PostForm.Get("From")
+		user, err := models.FindUser(db, func(u *models.User) bool { return u.PhoneVerified && u.SamePhone(from) })
+		if errors.Is(err, models.UserNotFound) {
+			user = nil
+		} else if err != nil {
+			reqLog(req).Errorf("finding user for inbound SMS from %q: %s", from, err)
+			return
+		}
+
+		// Compliance keywords are honored, and answered, from any number,
+		// even one with no account or that has opted out; everything else
+		// is ignored for those.
+		body := req.PostForm.Get("Body")
+		var reply string
+		keyword := models.ParseSmsKeyword(body)
+		switch keyword {
+		case models.SmsKeywordStop:
+			reply = "You're unsubscribed from vator and won't get any more texts. Reply START to resubscribe."
+			setSmsConsent(db, from, models.SmsConsentRevoked, "sms: "+body)
+		case models.SmsKeywordStart:
+			reply = "You're resubscribed to vator texts. Reply HELP for help, STOP to unsubscribe."
+			setSmsConsent(db, from, models.SmsConsentGranted, "sms: "+body)
+		case models.SmsKeywordHelp:
+			reply = smsComplianceHelp
+		default:
+			if user == nil {
+				reqLog(req).Warningf("inbound SMS from unknown or unverified number %q", from)
+				return
+			}
+			if !user.SmsAllowed() {
+				reqLog(req).Infof("ignoring SMS from %q, who has opted out", user.Username)
+				return
+			}
+			reply = smsCommand(db, notifier, user, body)
+		}
+
+		if reply == "" {
+			return
+		}
+		sid, err := notifier.Twilio.SendSms(from, reply)
+		if user != nil {
+			models.RecordSent(db, user.Username, models.ChannelSms, models.Notification{Kind: "reply", Body: reply}, sid, err)
+		}
+		if err != nil {
+			reqLog(req).Errorf("replying to %q: %s", from, err)
+		}
+	}
+}
+
+// SmsStatusHandler receives Twilio's message status callbacks and records the
+// reported delivery status in the notification history.
+func SmsStatusHandler(db *bbolt.DB, notifier *models.Notifier, webhookUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if notifier.Twilio == nil {
+			Bail(rw, req, errors.New("status callback received, but twilio is not configured"), http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := req.ParseForm(); err != nil {
+			Bail(rw, req, err, http.StatusBadRequest)
+			return
+		}
+
+		if !notifier.Twilio.ValidSignature(webhookUrl, req.PostForm, req.Header.Get("X-Twilio-Signature")) {
+			Bail(rw, req, fmt.Errorf("invalid twilio signature for %q", webhookUrl), http.StatusForbidden)
+			return
+		}
+
+		sid := req.PostForm.Get("MessageSid")
+		status := req.PostForm.Get("MessageStatus")
+		err := models.UpdateDeliveryStatus(db, models.ChannelSms, sid, status, req.PostForm.Get("ErrorCode"))
+		if err != nil {
+			reqLog(req).Warningf("recording status %q for message %q: %s", status, sid, err)
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// setSmsConsent records a change to SMS consent for every user with the given
+// phone number, since STOP and START apply to the number, not one account.
+func setSmsConsent(db *bbolt.DB, phone string, state models.SmsConsent, source string) {
+	users, err := models.FindUsers(db, func(u *models.User) bool { return u.SamePhone(phone) })
+	if err != nil {
+		Log.Errorf("finding users with phone %q: %s", phone, err)
+		return
+	}
+	for _, user := range users {
+		_, err := models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.SetSmsConsent(state, source)
+			return nil
+		})
+		if err != nil {
+			Log.Errorf("saving sms consent for %q: %s", user.Username, err)
+		}
+	}
+}
+
+// smsCommand carries out the command in body on behalf of user and returns the
+// reply, if any.
+func smsCommand(db *bbolt.DB, notifier *models.Notifier, user *models.User, body string) string {
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return smsHelp
+	}
+	command := strings.ToUpper(fields[0])
+
+	switch command {
+	case "STATUS":
+		return user.StatusMessage(notifier.Clock.Now())
+	case "LOG":
+		return logWeightCommand(db, notifier, user, command, fields[1:])
+	case "SUMMARY":
+		notifier.Go(func() { user.Summary(notifier, db, true) })
+		return ""
+	case "PAUSE", "RESUME":
+		paused := command == "PAUSE"
+		_, err := models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.Paused = paused
+			return nil
+		})
+		if err != nil {
+			Log.Errorf("saving paused=%t for %q: %s", paused, user.Username, err)
+			return "very sorry! afraid something went wrong..."
+		}
+		if paused {
+			return "OK, notifications paused. Text RESUME to turn them back on."
+		}
+		return "Welcome back! Notifications resumed."
+	default:
+		return smsHelp
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+const smsWebhookUrl = "https://vator.example.com/twilio/sms"
+
+// signTwilio returns the X-Twilio-Signature for a POST of form to fullUrl.
+func signTwilio(token, fullUrl string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	payload := fullUrl
+	for _, k := range keys {
+		payload += k + form.Get(k)
+	}
+	mac := hmac.New(sha1.New, []byte(token))
+	mac.Write([]byte(payload))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// smsTexts collects the texts a fake twilio was asked to send.
+type smsTexts struct {
+	mu   sync.Mutex
+	sent []url.Values
+}
+
+// to returns the bodies of the texts sent to phone.
+func (s *smsTexts) to(phone string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bodies []string
+	for _, text := range s.sent {
+		if text.Get("To") == phone {
+			bodies = append(bodies, text.Get("Body"))
+		}
+	}
+	return bodies
+}
+
+// smsHandler returns an SmsHandler whose replies go to a fake twilio, and
+// what it sends.
+func smsHandler(t *testing.T, db *bbolt.DB) (http.HandlerFunc, *smsTexts) {
+	t.Helper()
+	texts := &smsTexts{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		texts.mu.Lock()
+		texts.sent = append(texts.sent, req.PostForm)
+		texts.mu.Unlock()
+		rw.Write([]byte(`{"sid":"SM1"}`))
+	}))
+	t.Cleanup(srv.Close)
+	twilio := &models.Twilio{Sid: "AC1", AuthToken: "twilio-token", TwilioOptions: models.TwilioOptions{
+		BaseUrl: srv.URL,
+		From:    "+15555550100",
+	}}
+	return SmsHandler(db, models.NewNotifier(db, twilio, nil), smsWebhookUrl), texts
+}
+
+// text posts a signed inbound SMS to handler.
+func text(t *testing.T, handler http.HandlerFunc, from, body string) {
+	t.Helper()
+	form := url.Values{"From": {from}, "Body": {body}}
+	req := httptest.NewRequest("POST", "/twilio/sms", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", signTwilio("twilio-token", smsWebhookUrl, form))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("texting %q: got status %d: %s", body, rec.Code, rec.Body)
+	}
+}
+
+func TestSmsConsentSharedPhone(t *testing.T) {
+	db := testDb(t)
+	handler, _ := smsHandler(t, db)
+
+	// A household sharing a phone, and someone else.
+	for _, u := range []*models.User{
+		{Username: "alice", Phone: "+15555550123", PhoneVerified: true},
+		{Username: "bob", Phone: "+15555550123", PhoneVerified: true},
+		{Username: "carol", Phone: "+15555550199", PhoneVerified: true},
+	} {
+		if err := u.Save(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		body    string
+		allowed bool
+	}{
+		{body: "STOP", allowed: false},
+		{body: "START", allowed: true},
+	} {
+		text(t, handler, "+1 555 555 0123", test.body)
+		for _, username := range []string{"alice", "bob"} {
+			u, err := models.LoadUser(db, username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.SmsAllowed() != test.allowed {
+				t.Errorf("after %s, %s has sms allowed %t, want %t", test.body, username, u.SmsAllowed(), test.allowed)
+			}
+		}
+		if carol, err := models.LoadUser(db, "carol"); err != nil || !carol.SmsAllowed() || len(carol.ConsentLog) != 0 {
+			t.Errorf("after %s from someone else's phone, got carol %+v, %v", test.body, carol, err)
+		}
+	}
+}
+
+func TestSmsUnknownNumber(t *testing.T) {
+	db := testDb(t)
+	handler, texts := smsHandler(t, db)
+	const stranger = "+15555550177"
+
+	for _, body := range []string{"HELP", "STOP", "STATUS"} {
+		text(t, handler, stranger, body)
+	}
+	replies := texts.to(stranger)
+	if len(replies) != 2 || replies[0] != smsComplianceHelp || !strings.Contains(replies[1], "unsubscribed") {
+		t.Errorf("got replies %q, want answers to HELP and STOP only", replies)
+	}
+}
+
+func TestSmsUnverifiedNumber(t *testing.T) {
+	db := testDb(t)
+	handler, texts := smsHandler(t, db)
+	const phone = "+15555550123"
+	if err := (&models.User{Username: "legacy", Phone: phone}).Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	text(t, handler, phone, "PAUSE")
+	if u, err := models.LoadUser(db, "legacy"); err != nil || u.Paused {
+		t.Errorf("got user %+v, %v; want PAUSE ignored from an unverified number", u, err)
+	}
+
+	text(t, handler, phone, "STOP")
+	if u, err := models.LoadUser(db, "legacy"); err != nil || u.SmsAllowed() {
+		t.Errorf("got user %+v, %v; want STOP honored from an unverified number", u, err)
+	}
+	if replies := texts.to(phone); len(replies) != 1 || !strings.Contains(replies[0], "unsubscribed") {
+		t.Errorf("got replies %q, want only the answer to STOP", replies)
+	}
+}
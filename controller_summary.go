@@ -3,12 +3,14 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/asymmetricia/vator/models"
 	"go.etcd.io/bbolt"
 )
 
-func SummaryHandler(db *bbolt.DB, twilio *models.Twilio) func(http.ResponseWriter, *http.Request) {
+func SummaryHandler(db *bbolt.DB, notifier *models.Notifier) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		u, err := models.LoadUserRequest(db, req)
 		if err != nil {
@@ -17,7 +19,7 @@ func SummaryHandler(db *bbolt.DB, twilio *models.Twilio) func(http.ResponseWrite
 
 		}
 
-		u.Summary(twilio, db, true)
+		u.Summary(notifier, db, true)
 
 		err = models.SessionSet(db, req, "toast", "summary is on its way!")
 		if err != nil {
@@ -27,3 +29,54 @@ func SummaryHandler(db *bbolt.DB, twilio *models.Twilio) func(http.ResponseWrite
 		http.Redirect(rw, req, "/", http.StatusFound)
 	}
 }
+
+// SummaryScheduleHandler saves when, and how often, the user receives
+// summaries, and whether they receive monthly and yearly reports.
+func SummaryScheduleHandler(db *bbolt.DB, scheduler *Scheduler) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("should be logged in, but: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		var cadence *models.SummaryCadence
+		for i, c := range models.SummaryCadences {
+			if c.String() == req.Form.Get("cadence") {
+				cadence = &models.SummaryCadences[i]
+			}
+		}
+		day, dayErr := strconv.Atoi(req.Form.Get("day"))
+		hour, hourErr := strconv.Atoi(req.Form.Get("hour"))
+		reports := req.Form.Get("reports")
+
+		if cadence == nil || dayErr != nil || day < 0 || day > 6 || hourErr != nil || hour < 0 || hour > 23 ||
+			(reports != "on" && reports != "off") {
+			if err := models.SessionSet(db, req, "error", "that summary schedule didn't make sense; try again?"); err != nil {
+				Bail(rw, req, fmt.Errorf("setting error msg in session: %s", err), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(rw, req, "/", http.StatusFound)
+			return
+		}
+
+		_, err = models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.SummaryCadence = *cadence
+			u.SummaryDay = time.Weekday(day)
+			u.SummaryHour = hour
+			u.NoReports = reports == "off"
+			return nil
+		})
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("saving user %q: %s", user.Username, err), http.StatusInternalServerError)
+			return
+		}
+		scheduler.Reschedule()
+
+		if err := models.SessionSet(db, req, "toast", "summary schedule saved!"); err != nil {
+			Bail(rw, req, fmt.Errorf("setting toast msg in session: %s", err), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/", http.StatusFound)
+	}
+}
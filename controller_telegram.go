@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+func TelegramUnlinkHandler(db *bbolt.DB) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		user, err := models.LoadUserRequest(db, req)
+		if err != nil {
+			Bail(rw, req, err, http.StatusInternalServerError)
+			return
+		}
+
+		_, err = models.UpdateUser(db, user.Username, func(u *models.User) error {
+			u.TelegramChatId = 0
+			u.TelegramCode = ""
+			return nil
+		})
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("saving user %q: %s", user.Username, err), http.StatusInternalServerError)
+			return
+		}
+
+		err = models.SessionSet(db, req, "toast", "telegram unlinked!")
+		if err != nil {
+			Bail(rw, req, fmt.Errorf("setting toast msg in session: %s", err), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/", http.StatusFound)
+	}
+}
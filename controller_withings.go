@@ -5,13 +5,12 @@ import (
 	"net/http"
 
 	"github.com/asymmetricia/vator/models"
-	"github.com/asymmetricia/withings"
 	"go.etcd.io/bbolt"
 )
 
 type WithingsClient struct {
 	Db       *bbolt.DB
-	Withings *withings.Client
+	Withings *models.Withings
 }
 
 func (w *WithingsClient) Begin(rw http.ResponseWriter, req *http.Request) {
@@ -30,40 +29,41 @@ func (w *WithingsClient) Begin(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (w *WithingsClient) Complete(rw http.ResponseWriter, req *http.Request) {
-	RequireForm([]string{"code", "state"}, func(rw http.ResponseWriter, req *http.Request) {
-		user, err := models.LoadUserRequest(w.Db, req)
-		if err != nil {
-			Bail(rw, req, fmt.Errorf("loading user from db for request: %s", err), http.StatusInternalServerError)
-			return
-		}
+	user, err := models.LoadUserRequest(w.Db, req)
+	if err != nil {
+		Bail(rw, req, fmt.Errorf("loading user from db for request: %s", err), http.StatusInternalServerError)
+		return
+	}
 
-		err = ConsumeState(w.Db, req.Form.Get("state"))
+	err = ConsumeState(w.Db, req.Form.Get("state"))
 
-		if err != nil {
-			Bail(rw, req, fmt.Errorf("state %q: %s", req.Form.Get("state"), err), http.StatusBadRequest)
-			return
-		}
+	if err != nil {
+		Bail(rw, req, fmt.Errorf("state %q: %s", req.Form.Get("state"), err), http.StatusBadRequest)
+		return
+	}
 
-		withingsUser, err := w.Withings.NewUserFromAuthCode(req.Context(), req.Form.Get("code"))
-		if err != nil {
-			Bail(rw, req, fmt.Errorf("geting user from auth code %q: %s", req.Form.Get("code"), err), http.StatusBadRequest)
-			return
-		}
+	withingsUser, err := w.Withings.UserFromAuthCode(req.Context(), req.Form.Get("code"))
+	if err != nil {
+		Bail(rw, req, fmt.Errorf("geting user from auth code %q: %s", req.Form.Get("code"), err), http.StatusBadRequest)
+		return
+	}
 
-		token, err := withingsUser.Token()
-		if err != nil {
-			Bail(rw, req, fmt.Errorf("getting token for user: %w", err), http.StatusInternalServerError)
-			return
-		}
+	token, err := withingsUser.Token()
+	if err != nil {
+		Bail(rw, req, fmt.Errorf("getting token for user: %w", err), http.StatusInternalServerError)
+		return
+	}
 
-		user.AccessToken = token.AccessToken
-		user.RefreshSecret = token.RefreshToken
-		user.TokenExpiry = token.Expiry
-		if err := user.Save(w.Db); err != nil {
-			Bail(rw, req, fmt.Errorf("saving user: %s", err), http.StatusInternalServerError)
-			return
-		}
+	_, err = models.UpdateUser(w.Db, user.Username, func(u *models.User) error {
+		u.AccessToken = token.AccessToken
+		u.RefreshSecret = token.RefreshToken
+		u.TokenExpiry = token.Expiry
+		return nil
+	})
+	if err != nil {
+		Bail(rw, req, fmt.Errorf("saving user: %s", err), http.StatusInternalServerError)
+		return
+	}
 
-		http.Redirect(rw, req, "/", http.StatusFound)
-	})(rw, req)
+	http.Redirect(rw, req, "/", http.StatusFound)
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/asymmetricia/vator/metrics"
+	"github.com/asymmetricia/vator/models"
+	"github.com/asymmetricia/withings"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// asUser returns req as RequireAuth would pass it on for username.
+func asUser(req *http.Request, username string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), "user", username))
+}
+
+func TestWithingsLink(t *testing.T) {
+	fake := newFakeWithings(t)
+	db := testDb(t)
+	w := &WithingsClient{Db: db, Withings: fake.Client()}
+	callbackHandler := RequireForm("code", "state")(http.HandlerFunc(w.Complete))
+
+	if err := (&models.User{Username: "linker"}).Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Begin sends the user to withings to authorize vator...
+	rec := httptest.NewRecorder()
+	w.Begin(rec, asUser(httptest.NewRequest("GET", "/withings/begin", nil), "linker"))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("begin: got status %d, want %d", rec.Code, http.StatusFound)
+	}
+	authorize := rec.Header().Get("location")
+
+	// ... who approves, and is sent back to the callback.
+	noFollow := &http.Client{
+		Transport:     w.Withings.Http.Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	res, err := noFollow.Get(authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("location"))
+	if err != nil || callback.Query().Get("code") == "" {
+		t.Fatalf("authorize: got redirect %q (%v), want callback with a code", res.Header.Get("location"), err)
+	}
+
+	complete := func() int {
+		rec := httptest.NewRecorder()
+		callbackHandler.ServeHTTP(rec, asUser(httptest.NewRequest("GET", "/callback?"+callback.RawQuery, nil), "linker"))
+		return rec.Code
+	}
+	if code := complete(); code != http.StatusFound {
+		t.Fatalf("complete: got status %d, want %d", code, http.StatusFound)
+	}
+
+	user, err := models.LoadUser(db, "linker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.AccessToken == "" || !fake.RefreshValid(user.RefreshSecret) {
+		t.Errorf("after linking, got access token %q and refresh token %q, want tokens issued by withings",
+			user.AccessToken, user.RefreshSecret)
+	}
+	if until := time.Until(user.TokenExpiry); until < time.Duration(fake.ExpiresIn-60)*time.Second {
+		t.Errorf("after linking, token expires in %s, want about %ds", until, fake.ExpiresIn)
+	}
+
+	// The state is consumed, so the callback can't be replayed.
+	if code := complete(); code != http.StatusBadRequest {
+		t.Errorf("replayed complete: got status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestWithingsLinkBadState(t *testing.T) {
+	fake := newFakeWithings(t)
+	db := testDb(t)
+	w := &WithingsClient{Db: db, Withings: fake.Client()}
+
+	if err := (&models.User{Username: "linker"}).Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	RequireForm("code", "state")(http.HandlerFunc(w.Complete)).
+		ServeHTTP(rec, asUser(httptest.NewRequest("GET", "/callback?code=code-1&state=forged", nil), "linker"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	user, err := models.LoadUser(db, "linker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.RefreshSecret != "" {
+		t.Errorf("got refresh token %q, want none", user.RefreshSecret)
+	}
+}
+
+func TestSaveOauthTokensRefresh(t *testing.T) {
+	fake := newFakeWithings(t)
+	db := testDb(t)
+	client := fake.Client()
+
+	access, refresh := fake.Link()
+	user := &models.User{Username: "stale", AccessToken: access, RefreshSecret: refresh, TokenExpiry: time.Now().Add(-time.Hour)}
+	if err := user.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := testutil.ToFloat64(metrics.TokenRefreshes.WithLabelValues("ok"))
+	wtu, err := user.WithingsUser(db, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.Refreshes(); n != 1 {
+		t.Errorf("got %d refreshes, want 1", n)
+	}
+	if n := testutil.ToFloat64(metrics.TokenRefreshes.WithLabelValues("ok")) - refreshed; n != 1 {
+		t.Errorf("counted %v refreshes, want 1", n)
+	}
+	if fake.RefreshValid(refresh) {
+		t.Errorf("old refresh token %q is still valid", refresh)
+	}
+
+	saved, err := models.LoadUser(db, "stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.RefreshSecret != wtu.OauthToken.RefreshToken || saved.AccessToken != wtu.OauthToken.AccessToken {
+		t.Errorf("saved tokens %q/%q, want the refreshed %q/%q", saved.AccessToken, saved.RefreshSecret,
+			wtu.OauthToken.AccessToken, wtu.OauthToken.RefreshToken)
+	}
+	if !saved.TokenExpiry.After(time.Now()) {
+		t.Errorf("saved token expiry %s, want in the future", saved.TokenExpiry)
+	}
+
+	// The refreshed tokens are good for API calls, and aren't refreshed again
+	// while they last.
+	callback, _ := url.Parse("http://vator.test/withings/notify")
+	if _, err := wtu.CreateNotificationCtx(client.Context(context.Background()), &withings.CreateNotificationParam{CallbackURL: *callback, Appli: 1}); err != nil {
+		t.Fatalf("subscribing with refreshed token: %v", err)
+	}
+	if subs := fake.Subscriptions(); len(subs) != 1 || subs[0].Get("callbackurl") != callback.String() {
+		t.Errorf("got subscriptions %v, want one to %s", subs, callback)
+	}
+	if _, err := saved.WithingsUser(db, client); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.Refreshes(); n != 1 {
+		t.Errorf("got %d refreshes with an unexpired token, want still 1", n)
+	}
+}
+
+func TestSaveOauthTokensRevoked(t *testing.T) {
+	fake := newFakeWithings(t)
+	db := testDb(t)
+
+	user := &models.User{Username: "revoked", AccessToken: "gone", RefreshSecret: "revoked", TokenExpiry: time.Now().Add(-time.Hour)}
+	if err := user.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := testutil.ToFloat64(metrics.TokenRefreshes.WithLabelValues("error"))
+	if _, err := user.WithingsUser(db, fake.Client()); err == nil {
+		t.Fatal("got no error with a revoked refresh token")
+	}
+	if n := testutil.ToFloat64(metrics.TokenRefreshes.WithLabelValues("error")) - failed; n != 1 {
+		t.Errorf("counted %v failed refreshes, want 1", n)
+	}
+	saved, err := models.LoadUser(db, "revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.RefreshSecret != "revoked" {
+		t.Errorf("got refresh token %q, want it left alone", saved.RefreshSecret)
+	}
+}
@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/asymmetricia/vator/metrics"
 	"go.etcd.io/bbolt"
 )
 
 const StatesBucket = "states"
 
 func ConsumeState(db *bbolt.DB, state string) error {
-	return db.Update(func(tx *bbolt.Tx) error {
+	return metrics.Update(db, "state.consume", func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte(StatesBucket))
 		if bucket == nil {
 			return errors.New("not found")
@@ -37,7 +38,7 @@ func ConsumeState(db *bbolt.DB, state string) error {
 	})
 }
 func SaveState(db *bbolt.DB, state string) error {
-	return db.Update(func(tx *bbolt.Tx) error {
+	return metrics.Update(db, "state.save", func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(StatesBucket))
 		if err != nil {
 			return fmt.Errorf("getting `%s` bucket: %s", StatesBucket, err)
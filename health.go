@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/metrics"
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// healthPingInterval is how often the notifiers are checked.
+	healthPingInterval = 5 * time.Minute
+	// healthTimeout bounds each check, so a wedged dependency is reported
+	// rather than wedging /readyz too.
+	healthTimeout = 10 * time.Second
+)
+
+// Health tracks what /readyz reports: whether the database is readable,
+// whether loops like the scanner are still ticking, and whether the notifiers
+// were reachable when last pinged. Only the first two decide readiness; an
+// unreachable notifier is reported, but vator can still do everything else,
+// and the outbox retries what it couldn't send. Clock tells how long ago each
+// loop last ticked.
+type Health struct {
+	Db    *bbolt.DB
+	Clock models.Clock
+
+	mu         sync.Mutex
+	heartbeats map[string]*heartbeat
+	pingers    map[string]func(ctx context.Context) error
+	pings      map[string]Check
+}
+
+type heartbeat struct {
+	last   time.Time
+	maxAge time.Duration
+}
+
+// Check is the outcome of one of /readyz's checks. Last is when it was last
+// checked, or for a loop, when it last ticked. An Informational check is
+// reported but doesn't make vator unready when it fails.
+type Check struct {
+	Ok            bool      `json:"ok"`
+	Error         string    `json:"error,omitempty"`
+	Last          time.Time `json:"last"`
+	Informational bool      `json:"informational,omitempty"`
+}
+
+// Readiness is what /readyz returns.
+type Readiness struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+func NewHealth(db *bbolt.DB) *Health {
+	return &Health{
+		Db:         db,
+		Clock:      models.WallClock,
+		heartbeats: map[string]*heartbeat{},
+		pingers:    map[string]func(ctx context.Context) error{},
+		pings:      map[string]Check{},
+	}
+}
+
+// Heartbeat registers a loop that must tick at least every maxAge for vator
+// to be ready, and returns the func the loop calls each time it does. The
+// loop has maxAge from now to tick the first time.
+func (h *Health) Heartbeat(name string, maxAge time.Duration) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	beat := &heartbeat{last: h.Clock.Now(), maxAge: maxAge}
+	h.heartbeats[name] = beat
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		beat.last = h.Clock.Now()
+	}
+}
+
+// AddPinger registers a dependency, like a notifier, that Run checks with
+// ping. Its check is informational, and counts as reachable until the first
+// ping.
+func (h *Health) AddPinger(name string, ping func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pingers[name] = ping
+	h.pings[name] = Check{Ok: true, Last: h.Clock.Now(), Informational: true}
+}
+
+// Run pings each dependency at once, then every healthPingInterval, until
+// ctx is done.
+func (h *Health) Run(ctx context.Context) {
+	ticker := time.NewTicker(healthPingInterval)
+	defer ticker.Stop()
+	for {
+		h.Ping(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ping checks each dependency once, recording the outcomes.
+func (h *Health) Ping(ctx context.Context) {
+	h.mu.Lock()
+	pingers := make(map[string]func(ctx context.Context) error, len(h.pingers))
+	for name, ping := range h.pingers {
+		pingers[name] = ping
+	}
+	h.mu.Unlock()
+
+	for name, ping := range pingers {
+		pingCtx, cancel := context.WithTimeout(ctx, healthTimeout)
+		err := ping(pingCtx)
+		cancel()
+
+		check := Check{Ok: err == nil, Last: h.Clock.Now(), Informational: true}
+		if err != nil {
+			// /readyz is public, and errors can carry tokens, e.g. in
+			// telegram's URLs.
+			check.Error = Redact(err.Error())
+			Log.Warningf("%s is unreachable: %s", name, err)
+		}
+		h.mu.Lock()
+		h.pings[name] = check
+		h.mu.Unlock()
+	}
+}
+
+// Readiness runs the checks that are quick enough to run on each request,
+// i.e. the database, and reports those along with the latest heartbeats and
+// pings.
+func (h *Health) Readiness() Readiness {
+	now := h.Clock.Now()
+	checks := map[string]Check{"db": h.checkDb(now)}
+
+	h.mu.Lock()
+	for name, beat := range h.heartbeats {
+		check := Check{Ok: now.Sub(beat.last) <= beat.maxAge, Last: beat.last}
+		if !check.Ok {
+			check.Error = "no tick for " + now.Sub(beat.last).Round(time.Second).String()
+		}
+		checks[name] = check
+	}
+	for name, check := range h.pings {
+		checks[name] = check
+	}
+	h.mu.Unlock()
+
+	ready := Readiness{Status: "ok", Checks: checks}
+	var failed []string
+	for name, check := range checks {
+		if !check.Ok && !check.Informational {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		ready.Status = "unavailable"
+		Log.Debugf("not ready: %v", failed)
+	}
+	return ready
+}
+
+// checkDb checks that the database can be read, giving up after
+// healthTimeout.
+func (h *Health) checkDb(now time.Time) Check {
+	done := make(chan error, 1)
+	go func() {
+		done <- metrics.View(h.Db, "health", func(tx *bbolt.Tx) error {
+			if b := tx.Bucket([]byte("users")); b != nil {
+				b.Stats()
+			}
+			return nil
+		})
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(healthTimeout):
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		return Check{Error: err.Error(), Last: now}
+	}
+	return Check{Ok: true, Last: now}
+}
+
+// Healthz reports that vator is alive, i.e. able to serve a request.
+func (h *Health) Healthz(rw http.ResponseWriter, req *http.Request) {
+	writeHealth(rw, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports the outcome of each check, failing with 503 if any but an
+// informational one did.
+func (h *Health) Readyz(rw http.ResponseWriter, req *http.Request) {
+	ready := h.Readiness()
+	status := http.StatusOK
+	if ready.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(rw, status, ready)
+}
+
+func writeHealth(rw http.ResponseWriter, status int, body interface{}) {
+	rw.Header().Set("content-type", "application/json")
+	rw.Header().Set("cache-control", "no-store")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(body); err != nil {
+		Log.Errorf("writing health response: %s", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asymmetricia/vator/models"
+)
+
+// readyz returns the status and body /readyz responds with.
+func readyz(t *testing.T, h *Health) (int, Readiness) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var ready Readiness
+	if err := json.NewDecoder(rec.Body).Decode(&ready); err != nil {
+		t.Fatalf("decoding /readyz: %v", err)
+	}
+	return rec.Code, ready
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHealth(testDb(t)).Healthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ok"`) {
+		t.Errorf("got %d %q, want 200 ok", rec.Code, rec.Body)
+	}
+}
+
+func TestReadyzScanner(t *testing.T) {
+	clock := models.NewFakeClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	h := NewHealth(testDb(t))
+	h.Clock = clock
+	tick := h.Heartbeat("scanner", 5*time.Minute)
+
+	if code, ready := readyz(t, h); code != http.StatusOK || ready.Status != "ok" {
+		t.Fatalf("at start, got %d %+v, want ready", code, ready)
+	}
+
+	clock.Advance(6 * time.Minute)
+	code, ready := readyz(t, h)
+	if code != http.StatusServiceUnavailable || ready.Status != "unavailable" {
+		t.Errorf("with a wedged scanner, got %d %q, want unavailable", code, ready.Status)
+	}
+	if check := ready.Checks["scanner"]; check.Ok || check.Error != "no tick for 6m0s" {
+		t.Errorf("got scanner check %+v", check)
+	}
+	if !ready.Checks["db"].Ok {
+		t.Errorf("got db check %+v, want ok", ready.Checks["db"])
+	}
+
+	tick()
+	if code, ready := readyz(t, h); code != http.StatusOK || !ready.Checks["scanner"].Last.Equal(clock.Now()) {
+		t.Errorf("after a tick, got %d %+v, want ready", code, ready)
+	}
+}
+
+func TestReadyzPingers(t *testing.T) {
+	h := NewHealth(testDb(t))
+	twilioErr := errors.New("connection refused")
+	h.AddPinger("twilio", func(ctx context.Context) error { return twilioErr })
+	h.AddPinger("telegram", func(ctx context.Context) error { return nil })
+
+	// Reachable until checked.
+	if code, _ := readyz(t, h); code != http.StatusOK {
+		t.Fatalf("before pinging, got %d, want ready", code)
+	}
+
+	// An unreachable notifier is reported, but vator is still ready.
+	h.Ping(context.Background())
+	code, ready := readyz(t, h)
+	if code != http.StatusOK || ready.Status != "ok" {
+		t.Errorf("with twilio unreachable, got %d %q, want ready", code, ready.Status)
+	}
+	if check := ready.Checks["twilio"]; check.Ok || !check.Informational || check.Error != "connection refused" {
+		t.Errorf("got twilio check %+v", check)
+	}
+	if check := ready.Checks["telegram"]; !check.Ok {
+		t.Errorf("got telegram check %+v, want ok", check)
+	}
+
+	twilioErr = nil
+	h.Ping(context.Background())
+	if _, ready := readyz(t, h); !ready.Checks["twilio"].Ok {
+		t.Errorf("once twilio is back, got twilio check %+v, want ok", ready.Checks["twilio"])
+	}
+}
+
+func TestReadyzRedacted(t *testing.T) {
+	h := NewHealth(testDb(t))
+	h.AddPinger("telegram", func(ctx context.Context) error {
+		_, err := http.Get("http://127.0.0.1:1/bot123:abc/getMe")
+		return err
+	})
+
+	h.Ping(context.Background())
+	_, ready := readyz(t, h)
+	check := ready.Checks["telegram"]
+	if check.Ok || !strings.Contains(check.Error, "botREDACTED/getMe") || strings.Contains(check.Error, "123:abc") {
+		t.Errorf("got telegram check %+v, want the error with the token redacted", check)
+	}
+}
+
+func TestReadyzDb(t *testing.T) {
+	db := testDb(t)
+	h := NewHealth(db)
+	db.Close()
+
+	code, ready := readyz(t, h)
+	if code != http.StatusServiceUnavailable || ready.Checks["db"].Ok || ready.Checks["db"].Error == "" {
+		t.Errorf("with the database closed, got %d %+v", code, ready.Checks["db"])
+	}
+}
@@ -1,12 +1,241 @@
+// Package log is vator's structured, leveled logging. Each Logger belongs to
+// a subsystem, e.g. "scanner" or "http", whose level can be set separately,
+// and carries fields that are added to each line it logs. Lines are written
+// as text or JSON, with phone numbers and secrets redacted.
 package log
 
-import "github.com/op/go-logging"
-
-var Log = logging.MustGetLogger("vator")
-var format = logging.MustStringFormatter(
-	`%{color}%{time:15:04:05.000} %{shortfunc} ▶ %{level:.4s} %{id:03x}%{color:reset} %{message}`,
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// Log is the logger for anything not in a more specific subsystem.
+var Log = New("vator")
+
+// Logger logs lines for a subsystem, with fields added by With.
+type Logger struct {
+	subsystem string
+	attrs     []slog.Attr
+	skip      int
+}
+
+// New returns a logger for the named subsystem.
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// With returns a logger that adds the given fields, as alternating keys and
+// values, to each line.
+func (l *Logger) With(args ...interface{}) *Logger {
+	ret := *l
+	ret.attrs = append(l.attrs[:len(l.attrs):len(l.attrs)], argsToAttrs(args)...)
+	return &ret
+}
+
+// CallerSkip returns a logger that attributes lines to the caller n frames
+// further up, for helpers like Bail that log on their callers' behalf.
+func (l *Logger) CallerSkip(n int) *Logger {
+	ret := *l
+	ret.skip += n
+	return &ret
+}
+
+func argsToAttrs(args []interface{}) []slog.Attr {
+	var attrs []slog.Attr
+	for len(args) > 0 {
+		if len(args) == 1 {
+			attrs = append(attrs, slog.Any("!BADKEY", args[0]))
+			break
+		}
+		attrs = append(attrs, slog.Any(fmt.Sprint(args[0]), args[1]))
+		args = args[2:]
+	}
+	return attrs
+}
+
+// Enabled reports whether lines at level are logged for l's subsystem.
+func (l *Logger) Enabled(level slog.Level) bool {
+	return level >= current.Load().levelFor(l.subsystem)
+}
+
+func (l *Logger) log(level slog.Level, msg string) {
+	s := current.Load()
+	if level < s.levelFor(l.subsystem) {
+		return
+	}
+
+	// Skip runtime.Callers, log, and the exported method that called it.
+	var pcs [1]uintptr
+	runtime.Callers(3+l.skip, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.AddAttrs(slog.String("subsystem", l.subsystem))
+	r.AddAttrs(l.attrs...)
+	_ = s.handler.Handle(context.Background(), r)
+}
+
+func (l *Logger) Debug(args ...interface{})   { l.log(slog.LevelDebug, fmt.Sprint(args...)) }
+func (l *Logger) Info(args ...interface{})    { l.log(slog.LevelInfo, fmt.Sprint(args...)) }
+func (l *Logger) Warning(args ...interface{}) { l.log(slog.LevelWarn, fmt.Sprint(args...)) }
+func (l *Logger) Error(args ...interface{})   { l.log(slog.LevelError, fmt.Sprint(args...)) }
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs at the error level, then exits.
+func (l *Logger) Fatal(args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+// Fatalf logs at the error level, then exits.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+type ctxKey struct{}
+
+// WithContext returns a copy of ctx carrying l, e.g. a logger with a request's
+// ID, for FromContext to return.
+func WithContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger ctx carries, or Log if none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*Logger); ok {
+		return l
+	}
+	return Log
+}
+
+// state is the output and levels set by Configure.
+type state struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+func (s *state) levelFor(subsystem string) slog.Level {
+	if level, ok := s.levels[subsystem]; ok {
+		return level
+	}
+	return s.level
+}
+
+var current atomic.Pointer[state]
+
 func init() {
-	logging.SetFormatter(format)
+	current.Store(&state{handler: newHandler(os.Stderr, "text"), level: slog.LevelInfo})
+}
+
+// Configure sets where lines are written, in which format ("text" or
+// "json"), and the levels, as parsed by ParseLevels.
+func Configure(w io.Writer, format, levels string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format %q; expected text or json", format)
+	}
+	level, subsystems, err := ParseLevels(levels)
+	if err != nil {
+		return err
+	}
+	current.Store(&state{handler: newHandler(w, format), level: level, levels: subsystems})
+	return nil
+}
+
+// ParseLevels parses a default level optionally followed by levels for
+// subsystems, e.g. "info,scanner=debug,http=warn". If no default is given,
+// it's info.
+func ParseLevels(s string) (slog.Level, map[string]slog.Level, error) {
+	level := slog.LevelInfo
+	subsystems := map[string]slog.Level{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, forSubsystem := strings.Cut(part, "=")
+		if !forSubsystem {
+			value = name
+		}
+		parsed, err := parseLevel(value)
+		if err != nil {
+			return 0, nil, err
+		}
+		if forSubsystem {
+			subsystems[strings.TrimSpace(name)] = parsed
+		} else {
+			level = parsed
+		}
+	}
+	return level, subsystems, nil
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q; expected debug, info, warn or error", s)
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       slog.LevelDebug,
+		ReplaceAttr: replaceAttr,
+	}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// replaceAttr shortens the source to file:line and redacts every value.
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.SourceKey {
+		if source, ok := a.Value.Any().(*slog.Source); ok {
+			return slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", filepath.Base(source.File), source.Line))
+		}
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(v.String()))
+		}
+	}
+	return a
 }
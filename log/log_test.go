@@ -0,0 +1,118 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// capture sends lines to the returned buffer, in format at levels, for the
+// length of the test.
+func capture(t *testing.T, format, levels string) *bytes.Buffer {
+	t.Helper()
+	prev := current.Load()
+	t.Cleanup(func() { current.Store(prev) })
+	var buf bytes.Buffer
+	if err := Configure(&buf, format, levels); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestJson(t *testing.T) {
+	buf := capture(t, "json", "info")
+	New("http").With("request_id", "abc123", "user", "alice").Infof("rendered %d weights", 3)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("decoding %q: %v", buf, err)
+	}
+	for key, want := range map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "rendered 3 weights",
+		"subsystem":  "http",
+		"request_id": "abc123",
+		"user":       "alice",
+	} {
+		if line[key] != want {
+			t.Errorf("got %s=%v, want %v", key, line[key], want)
+		}
+	}
+	if source, _ := line["source"].(string); !strings.HasPrefix(source, "log_test.go:") {
+		t.Errorf("got source %q, want this file", line["source"])
+	}
+}
+
+func TestLevels(t *testing.T) {
+	buf := capture(t, "text", "warn,scanner=debug")
+
+	New("scanner").Debug("scanner debug")
+	New("http").Info("http info")
+	New("http").Warning("http warning")
+	Log.Info("vator info")
+
+	out := buf.String()
+	for _, want := range []string{"scanner debug", "http warning"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q is missing %q", out, want)
+		}
+	}
+	for _, unwanted := range []string{"http info", "vator info"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("%q contains %q, below its subsystem's level", out, unwanted)
+		}
+	}
+	if New("http").Enabled(slog.LevelInfo) || !New("scanner").Enabled(slog.LevelDebug) {
+		t.Error("Enabled disagrees with the levels")
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	level, subsystems, err := ParseLevels(" debug , http=WARNING,notifier=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != slog.LevelDebug || subsystems["http"] != slog.LevelWarn || subsystems["notifier"] != slog.LevelError {
+		t.Errorf("got %v %v", level, subsystems)
+	}
+
+	if level, _, err := ParseLevels(""); err != nil || level != slog.LevelInfo {
+		t.Errorf("empty levels gave %v, %v; want info", level, err)
+	}
+	if _, _, err := ParseLevels("info,scanner=chatty"); err == nil {
+		t.Error("got no error for an unknown level")
+	}
+	if err := Configure(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("got no error for an unknown format")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	AddSecrets("hunter22", "k", "")
+	for in, want := range map[string]string{
+		"texting +15555550123 now":                         "texting +*********23 now",
+		`{"access_token":"abc","expires_in":10800}`:        `{"access_token":"REDACTED","expires_in":10800}`,
+		"grant_type=refresh_token&refresh_token=xyz":       "grant_type=refresh_token&refresh_token=REDACTED",
+		"Authorization: Bearer s3cr3t.tok":                 "Authorization: Bearer REDACTED",
+		"GET https://api.telegram.org/bot123:AAbb-c/getMe": "GET https://api.telegram.org/botREDACTED/getMe",
+		"consumer secret is hunter22":                      "consumer secret is REDACTED",
+		"keep the k":                                       "keep the k",
+		"weighed 80.5kg on 2026-10-19":                     "weighed 80.5kg on 2026-10-19",
+	} {
+		if got := Redact(in); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRedactedFields(t *testing.T) {
+	buf := capture(t, "text", "info")
+	Log.With("phone", "+15555550123").Errorf("sending: %s", errors.New("rejected +15555550123"))
+
+	if out := buf.String(); strings.Contains(out, "5550123") {
+		t.Errorf("%q contains the phone number", out)
+	}
+}
@@ -0,0 +1,66 @@
+package log
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Redacted replaces whatever is redacted from log lines.
+const Redacted = "REDACTED"
+
+// MinSecret is the shortest secret AddSecrets redacts. Anything shorter would
+// mangle innocent text it happens to turn up in, and is hardly secret anyway.
+const MinSecret = 8
+
+var (
+	// phonePattern matches phone numbers in E.164 form, as vator stores them.
+	phonePattern = regexp.MustCompile(`\+[1-9]\d{7,14}\b`)
+
+	// tokenPatterns match tokens in the places they tend to turn up: JSON
+	// bodies and form or query parameters from withings, bearer headers, and
+	// telegram's bot URLs.
+	tokenPatterns = []struct {
+		pattern *regexp.Regexp
+		replace string
+	}{
+		{regexp.MustCompile(`("(?:access_token|refresh_token|client_secret|auth_token)"\s*:\s*)"[^"]*"`), `${1}"` + Redacted + `"`},
+		{regexp.MustCompile(`\b(access_token|refresh_token|client_secret|auth_token)=[^&\s"]+`), `${1}=` + Redacted},
+		{regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9._~+/=-]+`), `${1}` + Redacted},
+		{regexp.MustCompile(`\bbot\d+:[A-Za-z0-9_-]+`), `bot` + Redacted},
+	}
+
+	// secrets replaces the strings given to AddSecrets, which are kept in
+	// secretPairs to build it.
+	secrets     atomic.Pointer[strings.Replacer]
+	secretsMu   sync.Mutex
+	secretPairs []string
+)
+
+// AddSecrets adds strings, e.g. API tokens from the configuration, that are
+// to be redacted wherever they appear. Strings shorter than MinSecret are
+// ignored.
+func AddSecrets(add ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, secret := range add {
+		if len(secret) >= MinSecret {
+			secretPairs = append(secretPairs, secret, Redacted)
+		}
+	}
+	secrets.Store(strings.NewReplacer(secretPairs...))
+}
+
+// Redact masks phone numbers, but for their last two digits, and tokens in s.
+func Redact(s string) string {
+	if r := secrets.Load(); r != nil {
+		s = r.Replace(s)
+	}
+	for _, t := range tokenPatterns {
+		s = t.pattern.ReplaceAllString(s, t.replace)
+	}
+	return phonePattern.ReplaceAllStringFunc(s, func(phone string) string {
+		return "+" + strings.Repeat("*", len(phone)-3) + phone[len(phone)-2:]
+	})
+}
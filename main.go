@@ -1,56 +1,28 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/asymmetricia/vator/config"
 	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/metrics"
 	"github.com/asymmetricia/vator/models"
-	"github.com/asymmetricia/withings"
 	"go.etcd.io/bbolt"
 	"golang.org/x/crypto/acme/autocert"
 )
 
-// RequireForm returns an func(http.ResponseWriter,*http.Request) that wraps the given `handler`, ensuring
-// it only receives requests that have form data, and requiring parameters with
-// the named given by `required`.
-func RequireForm(required []string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
-	return func(rw http.ResponseWriter, req *http.Request) {
-		if err := req.ParseForm(); err != nil {
-			http.Error(rw, fmt.Sprintf("An error occurred parsing your request: %s", err), http.StatusBadRequest)
-			return
-		}
-
-		for _, str := range required {
-			if req.Form.Get(str) == "" {
-				Log.Debugf("Missing parameter: %s", str)
-				http.Error(rw, fmt.Sprintf("Your request was missing required parameters."), http.StatusBadRequest)
-				return
-			}
-		}
-		handler(rw, req)
-	}
-}
-
-func RequireLink(db *bbolt.DB, handler func(w http.ResponseWriter, r *http.Request)) func(http.ResponseWriter, *http.Request) {
-	return func(rw http.ResponseWriter, req *http.Request) {
-		user, err := models.LoadUserRequest(db, req)
-		if err != nil {
-			Bail(rw, req, fmt.Errorf("should be logged in, but: %s", err), http.StatusInternalServerError)
-			return
-		}
-		if user.RefreshSecret == "" {
-			http.Redirect(rw, req, "/", http.StatusFound)
-			return
-		}
-		handler(rw, req)
-	}
-}
-
 func callbackUrl(proto string, domain string, port int, path string) string {
 	if proto == "https" && port == 443 || proto == "http" && port == 80 {
 		return proto + "://" + domain + "/" + path
@@ -59,133 +31,267 @@ func callbackUrl(proto string, domain string, port int, path string) string {
 }
 
 func main() {
-	consumerKey := flag.String("consumer-key", "", "oauth consumer key")
-	consumerSecret := flag.String("consumer-secret", "", "oauth consumer secret")
-	port := flag.Int("port", 0, "port to listen on (if 0, actual port will depend on whether TLS is enabled or not)")
-	callbackDomain := flag.String("callback-domain", "localhost", "fqdn for oauth callbacks")
-	callbackPort := flag.Int("callback-port", 0, "callback port; if zero, same as -port")
-	callbackProto := flag.String("callback-proto", "http", "protocol to use in requesting callbacks")
-	dbFile := flag.String("db-file", "vator.db", "path to the bolt database file used to persist state")
-
-	twilioSid := flag.String("twilio-sid", "", "twilio account SID")
-	twilioToken := flag.String("twilio-token", "", "twilio auth token")
-
-	tlsEnabled := flag.Bool("tls", false, "if true, will configure TLS using a certificate from letsencrypt")
-
-	flag.Parse()
-
-	if *port == 0 {
-		if *tlsEnabled {
-			*port = 443
-		} else {
-			*port = 80
+	cfg, err := config.Parse(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		Log.Fatal(err)
+	}
+
+	switch args := flag.Args(); {
+	case len(args) == 0:
+	case len(args) == 2 && args[0] == "config" && args[1] == "print":
+		if err := cfg.Print(os.Stdout); err != nil {
+			Log.Fatal(err)
 		}
+		if err := cfg.Validate(); err != nil {
+			Log.Fatalf("invalid configuration:\n%s", err)
+		}
+		return
+	default:
+		Log.Fatalf("unknown command %q; the only command is \"config print\"", strings.Join(args, " "))
 	}
 
-	for n, f := range map[string]*string{
-		"-consumer-key":    consumerKey,
-		"-consumer-secret": consumerSecret,
-	} {
-		if *f == "" {
-			log.Fatalf("%s must be provided", n)
+	if err := cfg.Validate(); err != nil {
+		Log.Fatalf("invalid configuration:\n%s", err)
+	}
+	if err := Configure(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		Log.Fatal(err)
+	}
+	AddSecrets(cfg.Secrets()...)
+	statusUrl := callbackUrl(cfg.CallbackProto, cfg.CallbackDomain, cfg.CallbackPort, "twilio/status")
+
+	if cfg.MessagesDir != "" {
+		if err := models.Messages.LoadDir(cfg.MessagesDir); err != nil {
+			Log.Fatalf("loading message catalog: %s", err)
 		}
 	}
 
 	var twilio *models.Twilio
-	if *twilioSid == "" || *twilioToken == "" {
+	if cfg.TwilioSid == "" || cfg.TwilioToken == "" {
 		Log.Warning("missing twilio-sid and/or twilio-token, toasts via SMS will not function")
 	} else {
-		var err error
-		twilio, err = models.NewTwilio(*twilioSid, *twilioToken)
+		twilio, err = models.NewTwilio(cfg.TwilioSid, cfg.TwilioToken, models.TwilioOptions{
+			BaseUrl:             cfg.TwilioApi,
+			From:                cfg.TwilioFrom,
+			MessagingServiceSid: cfg.TwilioMessagingService,
+			StatusCallback:      statusUrl,
+		})
 		if err != nil {
-			log.Fatalf("error connecting to twilio: %s", err)
+			Log.Fatalf("error connecting to twilio: %s", err)
 		}
 	}
 
-	if *callbackPort == 0 {
-		*callbackPort = *port
+	var telegram *models.Telegram
+	if cfg.TelegramToken == "" {
+		Log.Warning("missing telegram-token, toasts via telegram will not function")
+	} else {
+		telegram, err = models.NewTelegram(cfg.TelegramToken, cfg.TelegramApi)
+		if err != nil {
+			Log.Fatalf("error connecting to telegram: %s", err)
+		}
 	}
 
-	db, err := bbolt.Open(*dbFile, 0600, nil)
+	db, err := bbolt.Open(cfg.DbFile, 0600, nil)
 	if err != nil {
-		Log.Fatalf("opening bolt db file %q: %s", *dbFile, err)
+		Log.Fatalf("opening bolt db file %q: %s", cfg.DbFile, err)
 	}
-	defer db.Close()
 	models.TidyUsers(db)
+	models.TidyOutbox(db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	cbUrl := callbackUrl(*callbackProto, *callbackDomain, *callbackPort, "callback")
+	// loops are the long-running goroutines, which each return once ctx is
+	// done.
+	var loops sync.WaitGroup
+	run := func(loop func(ctx context.Context)) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			loop(ctx)
+		}()
+	}
+
+	notifier := models.NewNotifier(db, twilio, telegram)
+	if cfg.DryRun {
+		Log.Warning("dry run: messages will be stored in the outbox but not sent")
+		notifier.DryRun = true
+	}
+	run(notifier.Run)
+
+	cbUrl := callbackUrl(cfg.CallbackProto, cfg.CallbackDomain, cfg.CallbackPort, "callback")
 	Log.Infof("using callback URL %q", cbUrl)
-	withingsClient := new(withings.Client)
-	*withingsClient = withings.NewClient(*consumerKey, *consumerSecret, cbUrl)
+	// Withings requests get a client of their own, so that they can be
+	// counted.
+	withingsClient := models.NewWithings(cfg.ConsumerKey, cfg.ConsumerSecret, cbUrl,
+		&http.Client{Transport: &metrics.Transport{Base: http.DefaultTransport}})
 
-	minutely := time.NewTicker(time.Minute)
-	go func() {
-		for {
-			go func() {
-				time.Sleep(30 * time.Second)
-				BackfillMeasures(db, withingsClient)
-			}()
-			ScanMeasures(db, withingsClient, twilio)
-			<-minutely.C
+	health := NewHealth(db)
+	// The scanner ticks every minute and a half or so; a few missed ticks
+	// mean it's wedged.
+	scanned := health.Heartbeat("scanner", 5*time.Minute)
+	run(func(ctx context.Context) { ScanLoop(ctx, db, withingsClient, notifier, scanned) })
+	if twilio != nil {
+		health.AddPinger("twilio", twilio.Ping)
+	}
+	if telegram != nil {
+		health.AddPinger("telegram", telegram.Ping)
+	}
+	run(health.Run)
+
+	baseUrl := callbackUrl(cfg.CallbackProto, cfg.CallbackDomain, cfg.CallbackPort, "")
+
+	scheduler := NewScheduler(db,
+		models.SummaryJob(db, notifier),
+		models.ReportJob(db, notifier, models.ReportMonth, baseUrl),
+		models.ReportJob(db, notifier, models.ReportYear, baseUrl),
+		models.ReminderJob(db, notifier))
+	run(scheduler.Run)
+
+	if telegram != nil {
+		bot := &TelegramBot{
+			Db:       db,
+			Telegram: telegram,
+			Notifier: notifier,
+			BaseUrl:  baseUrl,
 		}
-	}()
+		run(bot.Run)
+	}
 
 	withings := WithingsClient{
 		Db:       db,
 		Withings: withingsClient,
 	}
 
-	sessionizer := http.NewServeMux()
-	sessionizer.HandleFunc("/", models.WithSession(db, http.DefaultServeMux.ServeHTTP))
-	sessionizer.HandleFunc("/login", models.WithNewSession(db, RequireNotAuth(db, LoginHandler(db))))
+	router := NewRouter()
+	router.Handle("/metrics", metrics.Handler(cfg.MetricsToken), "GET")
+	router.HandleFunc("/healthz", health.Healthz, "GET")
+	router.HandleFunc("/readyz", health.Readyz, "GET")
+
+	// Twilio's webhooks are signed rather than sessioned.
+	smsUrl := callbackUrl(cfg.CallbackProto, cfg.CallbackDomain, cfg.CallbackPort, "twilio/sms")
+	Log.Infof("using twilio inbound SMS webhook URL %q", smsUrl)
+	router.HandleFunc("/twilio/sms", SmsHandler(db, notifier, smsUrl), "POST")
+	router.HandleFunc("/twilio/status", SmsStatusHandler(db, notifier, statusUrl), "POST")
 
-	http.HandleFunc("/", RequireAuth(db, IndexHandler(db, withingsClient)))
+	sessioned := router.With(Session(db))
+	sessioned.Handle("/static/", http.FileServer(http.FS(static)), "GET")
+	sessioned.With(RequireForm("user")).HandleFunc("/graph", Graph(db), "GET")
+	sessioned.With(RequireForm("user")).HandleFunc("/data", Data(db), "GET")
 
-	http.HandleFunc("/withings/begin", RequireAuth(db, withings.Begin))
-	http.HandleFunc("/callback", RequireAuth(db, withings.Complete))
+	sessioned.With(RequireNotAuth(db)).HandleFunc("/signup", SignupHandlerGet(db), "GET")
+	sessioned.With(RequireNotAuth(db), RequireForm("username", "password", "confirm")).
+		HandleFunc("/signup", SignupHandlerPost(db), "POST")
+	router.With(NewSession(db), RequireNotAuth(db)).HandleFunc("/login", LoginHandlerGet, "GET")
+	router.With(NewSession(db), RequireNotAuth(db), RequireForm("username", "password")).
+		HandleFunc("/login", LoginHandlerPost(db), "POST")
 
-	http.HandleFunc("/signup", RequireNotAuth(db, SignupHandler(db)))
-	http.HandleFunc("/logout", RequireAuth(db, LogoutHandler(db)))
-	http.HandleFunc("/measures", RequireAuth(db, MeasuresHandler(db)))
+	authed := sessioned.With(RequireAuth(db))
+	authed.HandleFunc("/", IndexHandler(db, withingsClient, telegram), "GET")
+	authed.HandleFunc("/logout", LogoutHandler(db), "GET")
 
-	http.HandleFunc("/phone", RequireAuth(db, PhoneHandler(db)))
-	http.HandleFunc("/kgs", RequireAuth(db, KgsHandler(db)))
-	http.HandleFunc("/rename", RequireAuth(db, RenameHandler(db)))
-	http.HandleFunc("/share", RequireAuth(db, ShareHandler(db)))
-	http.HandleFunc("/summary", RequireAuth(db, RequireLink(db, SummaryHandler(db, twilio))))
+	authed.HandleFunc("/withings/begin", withings.Begin, "GET")
+	authed.With(RequireForm("code", "state")).HandleFunc("/callback", withings.Complete, "GET")
+	authed.HandleFunc("/measures", MeasuresHandler(db), "GET")
 
-	http.Handle("/static/", http.FileServer(http.FS(static)))
-	http.HandleFunc("/graph", Graph(db))
-	http.HandleFunc("/data", Data(db))
+	authed.With(RequireForm("phone")).HandleFunc("/phone", PhoneHandlerPost(db, twilio), "POST")
+	authed.With(RequireForm("code")).HandleFunc("/phone/verify", PhoneVerifyHandler(db), "POST")
+	authed.With(RequireForm("timezone", "quiet_start", "quiet_end", "daily_cap", "reminder_days")).
+		HandleFunc("/notifications", NotificationsHandler(db, scheduler), "POST")
+	authed.HandleFunc("/messages", MessagesHandler(db), "GET")
+	authed.With(RequireForm("language", "tone")).HandleFunc("/messages", MessagesHandlerPost(db), "POST")
+	authed.HandleFunc("/milestones", MilestonesHandler(db), "POST")
+	authed.HandleFunc("/kgs", KgsHandler(db), "POST")
+	authed.HandleFunc("/rename", RenameHandler(db), "GET")
+	authed.HandleFunc("/rename", RenameHandlerPost(db), "POST")
+	authed.HandleFunc("/share", ShareHandler(db), "POST")
+	authed.HandleFunc("/history", HistoryHandler(db), "GET")
+	authed.HandleFunc("/telegram/unlink", TelegramUnlinkHandler(db), "POST")
+	authed.With(RequireLink(db)).HandleFunc("/summary", SummaryHandler(db, notifier), "GET")
+	authed.With(RequireForm("cadence", "day", "hour", "reports")).HandleFunc("/summary/schedule", SummaryScheduleHandler(db, scheduler), "POST")
+	authed.HandleFunc("/report", ReportHandler(db), "GET")
+
+	// Requests are counted and logged by the pattern that routed them.
+	handler := Chain(router,
+		func(h http.Handler) http.Handler { return metrics.Instrument(router.Route, h) },
+		RequestLog(router.Route),
+		AccessLog,
+		Recover)
+
+	// servers are shut down gracefully on exit; if any fails, vator exits.
+	var servers []*http.Server
+	var failed atomic.Bool
+	serve := func(server *http.Server, listen func() error) {
+		servers = append(servers, server)
+		go func() {
+			if err := listen(); !errors.Is(err, http.ErrServerClosed) {
+				Log.Errorf("serving on %s: %s", server.Addr, err)
+				failed.Store(true)
+				stop()
+			}
+		}()
+	}
 
-	Log.Infof("Listening on port %d", *port)
+	Log.Infof("Listening on port %d", cfg.Port)
 
-	if *tlsEnabled {
+	if cfg.Tls {
 		certmgr := autocert.Manager{
 			Prompt:     autocert.AcceptTOS,
-			HostPolicy: autocert.HostWhitelist(*callbackDomain),
+			HostPolicy: autocert.HostWhitelist(cfg.CallbackDomain),
 		}
 
 		server := &http.Server{
-			Addr:      fmt.Sprintf(":%d", *port),
-			Handler:   sessionizer,
+			Addr:      fmt.Sprintf(":%d", cfg.Port),
+			Handler:   handler,
 			TLSConfig: &tls.Config{GetCertificate: certmgr.GetCertificate},
 		}
 
 		challengeServer := &http.Server{
 			Addr:    ":80",
-			Handler: certmgr.HTTPHandler(http.RedirectHandler(fmt.Sprintf("https://%s", *callbackDomain), http.StatusMovedPermanently)),
+			Handler: certmgr.HTTPHandler(http.RedirectHandler(fmt.Sprintf("https://%s", cfg.CallbackDomain), http.StatusMovedPermanently)),
 		}
 
-		go func() { Log.Fatal(challengeServer.ListenAndServe()) }()
-
-		Log.Fatal(server.ListenAndServeTLS("", ""))
+		serve(challengeServer, challengeServer.ListenAndServe)
+		serve(server, func() error { return server.ListenAndServeTLS("", "") })
 	} else {
 		server := &http.Server{
-			Addr:    fmt.Sprintf(":%d", *port),
-			Handler: sessionizer,
+			Addr:    fmt.Sprintf(":%d", cfg.Port),
+			Handler: handler,
 		}
 
-		Log.Fatal(server.ListenAndServe())
+		serve(server, server.ListenAndServe)
+	}
+
+	<-ctx.Done()
+	// A second signal stops vator at once.
+	stop()
+	Log.Infof("shutting down; waiting up to %s for work in progress", cfg.DrainTimeout)
+
+	drain, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout.Duration)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(drain); err != nil {
+			Log.Warningf("shutting down server on %s: %s", server.Addr, err)
+		}
+	}
+
+	// Requests, scans and scheduled jobs may all start toasts and the like,
+	// so those are waited for last.
+	done := make(chan struct{})
+	go func() {
+		loops.Wait()
+		notifier.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-drain.Done():
+		Log.Warning("timed out waiting for work in progress; closing the database anyway")
+	}
+
+	if err := db.Close(); err != nil {
+		Log.Errorf("closing bolt db: %s", err)
+	}
+	if failed.Load() {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,155 @@
+// Package metrics is vator's Prometheus instrumentation, served at /metrics.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ScanDuration is how long each scan for new weights, or backfill of
+	// old ones, takes across all users; kind is "scan" or "backfill".
+	ScanDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vator_scan_duration_seconds",
+		Help:    "How long each pass of the scanner takes, by kind (scan or backfill).",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"kind"})
+
+	// WeightsImported counts weigh-ins newly fetched from withings; source is
+	// "scan" or "backfill".
+	WeightsImported = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vator_weights_imported_total",
+		Help: "Weigh-ins imported from withings, by source (scan or backfill).",
+	}, []string{"source"})
+
+	// WithingsRequests counts requests to the withings API by action, e.g.
+	// "getmeas".
+	WithingsRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vator_withings_requests_total",
+		Help: "Requests to the withings API, by action.",
+	}, []string{"action"})
+
+	// WithingsErrors counts failed requests to the withings API by action and
+	// type of error: "network", "http_<code>", "decode", or "status_<code>"
+	// for an error status in the response body.
+	WithingsErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vator_withings_errors_total",
+		Help: "Failed requests to the withings API, by action and type of error.",
+	}, []string{"action", "type"})
+
+	// TokenRefreshes counts exchanges of a refresh token for new oauth
+	// tokens; outcome is "ok" or "error".
+	TokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vator_withings_token_refreshes_total",
+		Help: "Withings oauth token refreshes, by outcome.",
+	}, []string{"outcome"})
+
+	// Messages counts messages to users, e.g. toasts and summaries, by kind,
+	// channel, and outcome: "sent", "retry", "dead", or "dry_run".
+	Messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vator_messages_total",
+		Help: "Messages to users, by kind, channel and outcome.",
+	}, []string{"kind", "channel", "outcome"})
+
+	// HttpRequests counts requests served, by the route that handled them and
+	// the status returned.
+	HttpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vator_http_requests_total",
+		Help: "HTTP requests served, by route and status.",
+	}, []string{"route", "status"})
+
+	// HttpDuration is how long requests take to serve, by route.
+	HttpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vator_http_request_duration_seconds",
+		Help:    "How long HTTP requests take to serve, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// BoltTransactions is how long bolt transactions take, including waiting
+	// for the database, by kind ("view" or "update") and operation.
+	BoltTransactions = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vator_bolt_transaction_duration_seconds",
+		Help:    "How long bolt transactions take, by kind and operation.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"kind", "op"})
+)
+
+// Handler serves the metrics. If token isn't empty, requests must present it
+// as a bearer token.
+func Handler(token string) http.Handler {
+	metrics := promhttp.Handler()
+	if token == "" {
+		return metrics
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("authorization")), want) != 1 {
+			rw.Header().Set("www-authenticate", `Bearer realm="vator metrics"`)
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		metrics.ServeHTTP(rw, req)
+	})
+}
+
+// Instrument wraps handler to count and time its requests, labelled by the
+// route that route returns for each.
+func Instrument(route func(*http.Request) string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		handler.ServeHTTP(recorder, req)
+
+		r := route(req)
+		HttpRequests.WithLabelValues(r, strconv.Itoa(recorder.status)).Inc()
+		HttpDuration.WithLabelValues(r).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status a handler writes.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Update is db.Update, timed as op.
+func Update(db *bbolt.DB, op string, fn func(tx *bbolt.Tx) error) error {
+	defer observeTx("update", op, time.Now())
+	return db.Update(fn)
+}
+
+// View is db.View, timed as op.
+func View(db *bbolt.DB, op string, fn func(tx *bbolt.Tx) error) error {
+	defer observeTx("view", op, time.Now())
+	return db.View(fn)
+}
+
+func observeTx(kind, op string, start time.Time) {
+	BoltTransactions.WithLabelValues(kind, op).Observe(time.Since(start).Seconds())
+}
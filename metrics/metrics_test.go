@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHandlerToken(t *testing.T) {
+	WeightsImported.WithLabelValues("scan")
+
+	tests := []struct {
+		name          string
+		token, header string
+		want          int
+	}{
+		{name: "open", want: http.StatusOK},
+		{name: "no token", token: "s3cret", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "s3cret", header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "right token", token: "s3cret", header: "Bearer s3cret", want: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if test.header != "" {
+				req.Header.Set("authorization", test.header)
+			}
+			rec := httptest.NewRecorder()
+			Handler(test.token).ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Errorf("got status %d, want %d", rec.Code, test.want)
+			}
+			if rec.Code == http.StatusOK && !strings.Contains(rec.Body.String(), "vator_weights_imported_total") {
+				t.Errorf("vator's metrics are missing:\n%s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	handler := Instrument(func(req *http.Request) string { return "/test" + req.URL.Path },
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/missing":
+				http.NotFound(rw, req)
+			case "/twice":
+				rw.WriteHeader(http.StatusAccepted)
+				rw.WriteHeader(http.StatusInternalServerError)
+			default:
+				io.WriteString(rw, "ok")
+			}
+		}))
+
+	for _, path := range []string{"/ok", "/ok", "/missing", "/twice"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	for _, c := range []struct {
+		route, status string
+		want          float64
+	}{
+		{"/test/ok", "200", 2},
+		{"/test/missing", "404", 1},
+		{"/test/twice", "202", 1},
+		{"/test/twice", "500", 0},
+	} {
+		if got := testutil.ToFloat64(HttpRequests.WithLabelValues(c.route, c.status)); got != c.want {
+			t.Errorf("%s %s: got %v requests, want %v", c.route, c.status, got, c.want)
+		}
+	}
+}
+
+// roundTripFunc is a canned http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func respond(status int, body string) roundTripFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	}
+}
+
+func TestTransport(t *testing.T) {
+	refresh := url.Values{"action": {"requesttoken"}, "grant_type": {"refresh_token"}}.Encode()
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		base    roundTripFunc
+		action  string
+		errType string
+		refresh string
+	}{
+		{
+			name:   "getmeas",
+			method: "GET", url: "https://wbsapi.withings.net/measure?action=getmeas",
+			base:   respond(200, `{"status":0,"body":{}}`),
+			action: "getmeas",
+		},
+		{
+			name:   "error status",
+			method: "GET", url: "https://wbsapi.withings.net/measure?action=getmeas",
+			base:   respond(200, `{"status":401,"error":"invalid token"}`),
+			action: "getmeas", errType: "status_401",
+		},
+		{
+			name:   "server error",
+			method: "POST", url: "https://wbsapi.withings.net/notify", body: "action=subscribe",
+			base:   respond(502, "bad gateway"),
+			action: "subscribe", errType: "http_502",
+		},
+		{
+			name:   "not json",
+			method: "GET", url: "https://wbsapi.withings.net/measure?action=getmeas",
+			base:   respond(200, "<html>"),
+			action: "getmeas", errType: "decode",
+		},
+		{
+			name:   "network",
+			method: "GET", url: "https://wbsapi.withings.net/measure?action=getmeas",
+			base: func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("connection refused")
+			},
+			action: "getmeas", errType: "network",
+		},
+		{
+			name:   "refresh",
+			method: "POST", url: "https://wbsapi.withings.net/v2/oauth2", body: refresh,
+			base:   respond(200, `{"status":0,"body":{"access_token":"a"}}`),
+			action: "requesttoken", refresh: "ok",
+		},
+		{
+			name:   "failed refresh",
+			method: "POST", url: "https://wbsapi.withings.net/v2/oauth2", body: refresh,
+			base:   respond(200, `{"status":503,"error":"invalid refresh_token"}`),
+			action: "requesttoken", errType: "status_503", refresh: "error",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := testutil.ToFloat64(WithingsRequests.WithLabelValues(test.action))
+			var errs, refreshes float64
+			if test.errType != "" {
+				errs = testutil.ToFloat64(WithingsErrors.WithLabelValues(test.action, test.errType))
+			}
+			if test.refresh != "" {
+				refreshes = testutil.ToFloat64(TokenRefreshes.WithLabelValues(test.refresh))
+			}
+
+			var body io.Reader
+			if test.body != "" {
+				body = strings.NewReader(test.body)
+			}
+			req, err := http.NewRequest(test.method, test.url, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := (&Transport{Base: test.base}).RoundTrip(req)
+			if test.errType == "network" {
+				if err == nil {
+					t.Error("the network error was swallowed")
+				}
+			} else {
+				// The body is left for the caller.
+				want, _ := test.base(req)
+				got, _ := io.ReadAll(res.Body)
+				wantBody, _ := io.ReadAll(want.Body)
+				if string(got) != string(wantBody) {
+					t.Errorf("got body %q, want %q", got, wantBody)
+				}
+			}
+
+			if got := testutil.ToFloat64(WithingsRequests.WithLabelValues(test.action)); got != requests+1 {
+				t.Errorf("got %v %s requests, want %v", got, test.action, requests+1)
+			}
+			if test.errType != "" {
+				if got := testutil.ToFloat64(WithingsErrors.WithLabelValues(test.action, test.errType)); got != errs+1 {
+					t.Errorf("got %v %s errors, want %v", got, test.errType, errs+1)
+				}
+			}
+			if test.refresh != "" {
+				if got := testutil.ToFloat64(TokenRefreshes.WithLabelValues(test.refresh)); got != refreshes+1 {
+					t.Errorf("got %v %s refreshes, want %v", got, test.refresh, refreshes+1)
+				}
+			}
+		})
+	}
+}
+
+func TestTransportOtherHosts(t *testing.T) {
+	before := testutil.CollectAndCount(WithingsRequests)
+	req := httptest.NewRequest("GET", "https://api.telegram.org/bot/getMe", nil)
+	if _, err := (&Transport{Base: respond(200, "{}")}).RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if after := testutil.CollectAndCount(WithingsRequests); after != before {
+		t.Errorf("a telegram request was counted as withings'")
+	}
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WithingsApiHost is the host withings' API is served from.
+const WithingsApiHost = "wbsapi.withings.net"
+
+// Transport counts the requests made through Base to the withings API, their
+// errors, and token refreshes; other requests are passed through untouched.
+// Withings reports most errors in the response body with a 200, so those are
+// read to tell.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != WithingsApiHost {
+		return t.Base.RoundTrip(req)
+	}
+
+	params := withingsParams(req)
+	action := params.Get("action")
+	if action == "" {
+		action = strings.Trim(req.URL.Path, "/")
+	}
+	WithingsRequests.WithLabelValues(action).Inc()
+
+	res, errType, err := t.roundTrip(req)
+	if errType != "" {
+		WithingsErrors.WithLabelValues(action, errType).Inc()
+	}
+	if action == "requesttoken" && params.Get("grant_type") == "refresh_token" {
+		outcome := "ok"
+		if errType != "" {
+			outcome = "error"
+		}
+		TokenRefreshes.WithLabelValues(outcome).Inc()
+	}
+	return res, err
+}
+
+// roundTrip sends req, returning the type of error withings reported, if any.
+// The response body is left for the caller to read again.
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, string, error) {
+	res, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, "network", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return res, fmt.Sprintf("http_%d", res.StatusCode), nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, "network", err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	var envelope struct {
+		Status *int `json:"status"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Status == nil {
+		return res, "decode", nil
+	}
+	if *envelope.Status != 0 {
+		return res, fmt.Sprintf("status_%d", *envelope.Status), nil
+	}
+	return res, "", nil
+}
+
+// withingsParams returns the parameters of a withings request, which may be in
+// its query or its form-encoded body.
+func withingsParams(req *http.Request) url.Values {
+	params := req.URL.Query()
+	if req.GetBody == nil {
+		return params
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return params
+	}
+	defer body.Close()
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return params
+	}
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return params
+	}
+	for k, v := range form {
+		params[k] = append(params[k], v...)
+	}
+	return params
+}
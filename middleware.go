@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+// Session gives each request a session, starting one if the request's is
+// missing or invalid.
+func Session(db *bbolt.DB) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(models.WithSession(db, handler.ServeHTTP))
+	}
+}
+
+// NewSession gives each request a new session, e.g. on logging in.
+func NewSession(db *bbolt.DB) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(models.WithNewSession(db, handler.ServeHTTP))
+	}
+}
+
+// RequireForm ensures requests have form data, with each of the required
+// parameters.
+func RequireForm(required ...string) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if err := req.ParseForm(); err != nil {
+				http.Error(rw, fmt.Sprintf("An error occurred parsing your request: %s", err), http.StatusBadRequest)
+				return
+			}
+
+			for _, str := range required {
+				if req.Form.Get(str) == "" {
+					reqLog(req).Debugf("missing parameter: %s", str)
+					http.Error(rw, fmt.Sprintf("Your request was missing required parameters."), http.StatusBadRequest)
+					return
+				}
+			}
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// RequireLink sends users who haven't linked their withings account home.
+// It must follow RequireAuth.
+func RequireLink(db *bbolt.DB) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			user, err := models.LoadUserRequest(db, req)
+			if err != nil {
+				Bail(rw, req, fmt.Errorf("should be logged in, but: %s", err), http.StatusInternalServerError)
+				return
+			}
+			if user.RefreshSecret == "" {
+				http.Redirect(rw, req, "/", http.StatusFound)
+				return
+			}
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// Recover logs a panicking handler's panic and stack, and responds with the
+// friendly error page if the handler hadn't yet responded.
+func Recover(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		recorder := &responseRecorder{ResponseWriter: rw, status: http.StatusOK}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// net/http quietly aborts the response for this one.
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			reqLog(req).With("stack", string(debug.Stack())).Errorf("panic: %v", p)
+			if !recorder.wroteHeader {
+				Sorry(recorder, req, http.StatusInternalServerError)
+			}
+		}()
+		handler.ServeHTTP(recorder, req)
+	})
+}
@@ -0,0 +1,383 @@
+package models
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbroglie/mustache"
+)
+
+// DefaultLocale and DefaultTone are used for users who haven't chosen, and
+// for any message that has no template in the user's locale or tone.
+const (
+	DefaultLocale = "en"
+	DefaultTone   = "cheerful"
+)
+
+// Tones are the styles of message users can choose from, in the order they
+// should be offered.
+var Tones = []string{"cheerful", "terse", "drill-sergeant"}
+
+// Events are the kinds of message rendered from the catalog, in the order
+// they are previewed.
+var Events = []string{
+	"toast",
+	"encouragement",
+	"not-enough-data",
+	"reminder",
+	"milestone-streak",
+	"milestone-loss",
+	"milestone-round",
+	"milestone-low",
+	"milestone-bmi",
+}
+
+//go:embed messages/*.json
+var embeddedMessages embed.FS
+
+// Template is a message template and how often, relative to the others for
+// the same event, it should be chosen.
+type Template struct {
+	Text   string
+	Weight float64
+}
+
+// UnmarshalJSON accepts either a bare string, which has a weight of 1, or an
+// object like {"text": "...", "weight": 2}.
+func (t *Template) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*t = Template{Text: text, Weight: 1}
+		return nil
+	}
+
+	var obj struct {
+		Text   string   `json:"text"`
+		Weight *float64 `json:"weight"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("template must be a string or {\"text\": ..., \"weight\": ...}: %w", err)
+	}
+	*t = Template{Text: obj.Text, Weight: 1}
+	if obj.Weight != nil {
+		t.Weight = *obj.Weight
+	}
+	if t.Weight < 0 {
+		return fmt.Errorf("template %q has negative weight %g", t.Text, t.Weight)
+	}
+	return nil
+}
+
+// catalogFile is the format of a locale's message file, e.g. messages/en.json:
+// number words for day counts, and templates keyed by tone then event. Events
+// under "tones" replace any templates already loaded for them, while those
+// under "add" are added to them.
+type catalogFile struct {
+	Days  map[int]string                   `json:"days"`
+	Tones map[string]map[string][]Template `json:"tones"`
+	Add   map[string]map[string][]Template `json:"add"`
+}
+
+// Catalog holds the mustache templates messages are rendered from, keyed by
+// locale, tone and event. The built-in templates may be overridden by an
+// operator; see LoadDir.
+type Catalog struct {
+	mu      sync.RWMutex
+	locales map[string]*catalogFile
+}
+
+// Messages is the catalog notifications are rendered from.
+var Messages = NewCatalog()
+
+// NewCatalog returns a catalog of the built-in templates.
+func NewCatalog() *Catalog {
+	c := &Catalog{locales: map[string]*catalogFile{}}
+	if err := c.load(embeddedMessages, "messages"); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// LoadDir reads locale files, named like the built-in ones, from dir. Each
+// event they define under "tones" replaces the built-in templates for that
+// locale and tone, and those under "add" are added to them; anything they
+// don't mention is left alone.
+func (c *Catalog) LoadDir(dir string) error {
+	if err := c.load(os.DirFS(dir), "."); err != nil {
+		return err
+	}
+	log.Infof("loaded message catalog overrides from %q", dir)
+	return nil
+}
+
+func (c *Catalog) load(fsys fs.FS, dir string) error {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("reading message catalog %q: %w", name, err)
+		}
+		var file catalogFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("parsing message catalog %q: %w", name, err)
+		}
+		for _, tones := range []map[string]map[string][]Template{file.Tones, file.Add} {
+			for tone, events := range tones {
+				for event, templates := range events {
+					for _, tmpl := range templates {
+						if _, err := mustache.ParseString(tmpl.Text); err != nil {
+							return fmt.Errorf("%s: %s/%s template %q: %w", name, tone, event, tmpl.Text, err)
+						}
+					}
+				}
+			}
+		}
+
+		locale := strings.TrimSuffix(path.Base(name), ".json")
+		existing, ok := c.locales[locale]
+		if !ok {
+			existing = &catalogFile{Days: map[int]string{}, Tones: map[string]map[string][]Template{}}
+			c.locales[locale] = existing
+		}
+		for n, word := range file.Days {
+			existing.Days[n] = word
+		}
+		for tone, events := range file.Tones {
+			if existing.Tones[tone] == nil {
+				existing.Tones[tone] = map[string][]Template{}
+			}
+			for event, templates := range events {
+				existing.Tones[tone][event] = templates
+			}
+		}
+		for tone, events := range file.Add {
+			if existing.Tones[tone] == nil {
+				existing.Tones[tone] = map[string][]Template{}
+			}
+			for event, templates := range events {
+				existing.Tones[tone][event] = append(existing.Tones[tone][event], templates...)
+			}
+		}
+	}
+	return nil
+}
+
+// Locales lists the locales the catalog has templates for.
+func (c *Catalog) Locales() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var locales []string
+	for locale := range c.locales {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Templates returns the templates for event in the given locale and tone,
+// falling back first to the default tone and then to the default locale.
+func (c *Catalog) Templates(locale, tone, event string) []Template {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, l := range []string{locale, DefaultLocale} {
+		for _, t := range []string{tone, DefaultTone} {
+			if file, ok := c.locales[l]; ok && len(file.Tones[t][event]) > 0 {
+				return file.Tones[t][event]
+			}
+		}
+	}
+	return nil
+}
+
+// Days spells out a number of days in the given locale, if the catalog knows
+// how, and otherwise writes it in digits.
+func (c *Catalog) Days(locale string, days int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if file, ok := c.locales[locale]; ok && file.Days[days] != "" {
+		return file.Days[days]
+	}
+	return strconv.Itoa(days)
+}
+
+// Locale is the user's chosen language, or the default.
+func (u *User) Locale() string {
+	if u.Language == "" {
+		return DefaultLocale
+	}
+	return u.Language
+}
+
+// MessageTone is the user's chosen tone, or the default.
+func (u *User) MessageTone() string {
+	if u.Tone == "" {
+		return DefaultTone
+	}
+	return u.Tone
+}
+
+// recentTemplateLimit is how many of the templates most recently sent to a
+// user are remembered, so that they aren't repeated too soon.
+const recentTemplateLimit = 20
+
+// pickTemplate chooses one of the user's templates for event and records it as
+// recently sent. The choice is weighted, but never one of the most recent
+// templates the user was sent for the event: up to half of them are excluded,
+// so with two templates they alternate.
+func (u *User) pickTemplate(event string) (string, error) {
+	templates := Messages.Templates(u.Locale(), u.MessageTone(), event)
+	if len(templates) == 0 {
+		return "", fmt.Errorf("no %s templates", event)
+	}
+
+	excluded := map[string]bool{}
+	for i := len(u.RecentTemplates) - 1; i >= 0 && len(excluded) < len(templates)/2; i-- {
+		for _, t := range templates {
+			if t.Text == u.RecentTemplates[i] {
+				excluded[t.Text] = true
+			}
+		}
+	}
+
+	var candidates []Template
+	var total float64
+	for _, t := range templates {
+		if !excluded[t.Text] && t.Weight > 0 {
+			candidates = append(candidates, t)
+			total += t.Weight
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = templates
+		total = 0
+	}
+
+	choice := candidates[rand.Intn(len(candidates))]
+	if total > 0 {
+		r := rand.Float64() * total
+		for _, t := range candidates {
+			if r -= t.Weight; r < 0 {
+				choice = t
+				break
+			}
+		}
+	}
+
+	u.rememberTemplate(choice.Text)
+	return choice.Text, nil
+}
+
+// rememberTemplate records that text was just used, so that it is less likely
+// to be picked again soon.
+func (u *User) rememberTemplate(text string) {
+	u.RecentTemplates = append(u.RecentTemplates, text)
+	if over := len(u.RecentTemplates) - recentTemplateLimit; over > 0 {
+		u.RecentTemplates = u.RecentTemplates[over:]
+	}
+}
+
+// renderMessage picks one of the user's templates for event and renders it
+// with ctx, returning both.
+func (u *User) renderMessage(event string, ctx map[string]string) (string, string, error) {
+	tmpl, err := u.pickTemplate(event)
+	if err != nil {
+		return "", "", err
+	}
+	return u.renderTemplate(tmpl, ctx)
+}
+
+func (u *User) renderTemplate(tmpl string, ctx map[string]string) (string, string, error) {
+	msg, err := mustache.Render(tmpl, ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("rendering template %q: %w", tmpl, err)
+	}
+	return tmpl, msg, nil
+}
+
+// MessagePreview is one template rendered as the user would receive it.
+type MessagePreview struct {
+	Event    string
+	Template string
+	Weight   float64
+	Message  string
+	Error    string
+}
+
+// PreviewMessages renders every template the user might be sent, using their
+// own numbers as of now where there are enough of them and plausible ones
+// otherwise.
+func (u *User) PreviewMessages(now time.Time) []MessagePreview {
+	current, err := u.MovingAverageWeight(now, 5, 0)
+	if err != nil {
+		current = 80
+		for _, w := range u.Weights {
+			current = w.Kgs
+		}
+	}
+	delta := 0.5
+	if prev, err := u.MovingAverageWeight(now, 5, 1); err == nil && prev != current {
+		delta = math.Abs(prev - current)
+	}
+
+	start := u.MilestoneStartKgs
+	if start == 0 {
+		start = current + u.milestoneStep()
+	}
+	reminderDays := u.ReminderDays
+	if reminderDays == 0 {
+		reminderDays = 3
+	}
+	bmi, from, to := 24.9, "overweight", "healthy"
+	if u.HeightCm > 0 {
+		bmi = current / math.Pow(u.HeightCm/100, 2)
+		i := bmiCategory(bmi)
+		to = bmiCategories[i].Name
+		if i+1 < len(bmiCategories) {
+			from = bmiCategories[i+1].Name
+		} else {
+			from = bmiCategories[i-1].Name
+		}
+	}
+
+	contexts := map[string]map[string]string{
+		"toast":            u.trendContext(5, current+delta, current),
+		"encouragement":    u.trendContext(30, current-delta, current),
+		"reminder":         u.reminderContext(reminderDays),
+		"milestone-streak": u.streakContext(streakMilestones[0]),
+		"milestone-loss":   u.lossContext(start, current),
+		"milestone-round":  u.roundContext(math.Ceil(current*u.unitFactor()/10)*10, current),
+		"milestone-low":    u.lowContext(current),
+		"milestone-bmi":    bmiContext(bmi, from, to),
+	}
+
+	var previews []MessagePreview
+	for _, event := range Events {
+		for _, tmpl := range Messages.Templates(u.Locale(), u.MessageTone(), event) {
+			preview := MessagePreview{Event: event, Template: tmpl.Text, Weight: tmpl.Weight}
+			if _, msg, err := u.renderTemplate(tmpl.Text, contexts[event]); err != nil {
+				preview.Error = err.Error()
+			} else {
+				preview.Message = msg
+			}
+			previews = append(previews, preview)
+		}
+	}
+	return previews
+}
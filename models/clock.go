@@ -0,0 +1,51 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Whatever runs on a schedule, like the Notifier, has a
+// Clock rather than calling time.Now, so that it can be tested and simulated,
+// e.g. by `vatorctl simulate`; the times it works out are passed along to
+// what it calls.
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time { return time.Now() }
+
+// WallClock is the real time, and the default Clock.
+var WallClock Clock = wallClock{}
+
+// FakeClock is a Clock that only moves when it is told to.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now.
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// SmsConsent records whether a user has agreed to receive text messages. The
+// zero value is treated as consent, since every number on file was entered by
+// its owner.
+type SmsConsent string
+
+const (
+	SmsConsentGranted SmsConsent = "granted"
+	SmsConsentRevoked SmsConsent = "revoked"
+)
+
+// ConsentChange is one entry in a user's SMS consent audit trail.
+type ConsentChange struct {
+	Date   time.Time
+	Phone  string
+	State  SmsConsent
+	Source string
+}
+
+type SmsKeyword int
+
+const (
+	SmsKeywordNone SmsKeyword = iota
+	SmsKeywordStop
+	SmsKeywordStart
+	SmsKeywordHelp
+)
+
+// smsKeywords are the carrier-mandated opt-out, opt-in and help keywords.
+var smsKeywords = map[string]SmsKeyword{
+	"STOP":        SmsKeywordStop,
+	"STOPALL":     SmsKeywordStop,
+	"UNSUBSCRIBE": SmsKeywordStop,
+	"CANCEL":      SmsKeywordStop,
+	"END":         SmsKeywordStop,
+	"QUIT":        SmsKeywordStop,
+	"OPTOUT":      SmsKeywordStop,
+	"REVOKE":      SmsKeywordStop,
+	"START":       SmsKeywordStart,
+	"UNSTOP":      SmsKeywordStart,
+	"YES":         SmsKeywordStart,
+	"OPTIN":       SmsKeywordStart,
+	"HELP":        SmsKeywordHelp,
+	"INFO":        SmsKeywordHelp,
+}
+
+// ParseSmsKeyword identifies body as one of the compliance keywords. Like
+// carriers do, it only matches when the keyword is the entire message.
+func ParseSmsKeyword(body string) SmsKeyword {
+	return smsKeywords[strings.ToUpper(strings.TrimSpace(body))]
+}
+
+// SmsAllowed reports whether the user may be sent text messages.
+func (u *User) SmsAllowed() bool {
+	return u.SmsConsent != SmsConsentRevoked
+}
+
+// SetSmsConsent updates the user's SMS consent and records the change, along
+// with what caused it, in ConsentLog. The caller is responsible for saving.
+func (u *User) SetSmsConsent(state SmsConsent, source string) {
+	u.SmsConsent = state
+	u.ConsentLog = append(u.ConsentLog, ConsentChange{
+		Date:   time.Now(),
+		Phone:  u.Phone,
+		State:  state,
+		Source: source,
+	})
+	log.Infof("user %q sms consent for %q is now %s (%s)", u.Username, u.Phone, state, source)
+}
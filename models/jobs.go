@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Job is something the scheduler does for each user. Next returns when it is
+// next due for u, or the zero time if never; Run does it.
+type Job struct {
+	Name string
+	Next func(u *User, now time.Time) time.Time
+	Run  func(u *User)
+}
+
+// SummaryJob sends each user their summary according to their cadence.
+func SummaryJob(db *bbolt.DB, notifier *Notifier) Job {
+	return Job{
+		Name: "summary",
+		Next: func(u *User, now time.Time) time.Time {
+			if u.Paused {
+				return time.Time{}
+			}
+			return u.NextSummary(now)
+		},
+		Run: func(u *User) {
+			u.Summary(notifier, db, false)
+		},
+	}
+}
+
+// ReportJob sends each user their report for every period that ends, with a
+// link to the full report under baseUrl.
+func ReportJob(db *bbolt.DB, notifier *Notifier, period ReportPeriod, baseUrl string) Job {
+	return Job{
+		Name: string(period) + " report",
+		Next: func(u *User, now time.Time) time.Time {
+			if u.Paused {
+				return time.Time{}
+			}
+			return u.NextReport(period, now)
+		},
+		Run: func(u *User) {
+			u.SendReport(notifier, db, period, baseUrl, false)
+		},
+	}
+}
+
+// ReminderJob reminds users who haven't weighed in for a while to do so.
+func ReminderJob(db *bbolt.DB, notifier *Notifier) Job {
+	return Job{
+		Name: "reminder",
+		Next: func(u *User, now time.Time) time.Time {
+			if u.Paused {
+				return time.Time{}
+			}
+			return u.NextReminder()
+		},
+		Run: func(u *User) {
+			u.Remind(notifier, db)
+		},
+	}
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Milestone is an achievement that has been celebrated. Key identifies it,
+// e.g. "streak:30" or "round:200lb", so that it is celebrated only once.
+type Milestone struct {
+	Key      string
+	Date     time.Time
+	Template string
+	Message  string
+}
+
+// streakMilestones are the lengths of weigh-in streak, in days, worth
+// celebrating.
+var streakMilestones = []int{30, 100, 365}
+
+// lowMilestoneGap is how long after a new low is celebrated before the next
+// one is, so that a steady loss isn't celebrated every day.
+const lowMilestoneGap = 7 * 24 * time.Hour
+
+// bmiCategories are the BMI categories in order, each with the BMI at which it
+// ends and how far it is from healthy; moving to a category closer to healthy
+// is a milestone.
+var bmiCategories = []struct {
+	Name     string
+	Below    float64
+	Distance int
+}{
+	{"underweight", 18.5, 1},
+	{"healthy", 25, 0},
+	{"overweight", 30, 1},
+	{"obese", math.Inf(1), 2},
+}
+
+// bmiCategory returns the index into bmiCategories of the category bmi falls
+// in.
+func bmiCategory(bmi float64) int {
+	for i, c := range bmiCategories {
+		if bmi < c.Below {
+			return i
+		}
+	}
+	return len(bmiCategories) - 1
+}
+
+// Achieved reports whether the milestone identified by key has already been
+// celebrated.
+func (u *User) Achieved(key string) bool {
+	for _, m := range u.Milestones {
+		if m.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// milestoneStep is the amount of loss, in kilograms, between loss milestones:
+// 5kg, or 10lb.
+func (u *User) milestoneStep() float64 {
+	if u.Kgs {
+		return 5
+	}
+	return 10 / PoundsFromKg
+}
+
+// streak is the number of consecutive local days, ending on the day of the
+// most recent weigh-in, on which the user weighed in.
+func (u *User) streak() int {
+	last := u.lastWeighIn()
+	if last.IsZero() {
+		return 0
+	}
+
+	daily := u.dailyWeights()
+	day := last.In(u.Timezone())
+	n := 0
+	for ; ; n++ {
+		if _, ok := daily[dayKey(day.AddDate(0, 0, -n))]; !ok {
+			return n
+		}
+	}
+}
+
+// newMilestones finds milestones the user has reached but not yet had
+// celebrated, and updates the records they are measured against.
+func (u *User) newMilestones(now time.Time) []Milestone {
+	var found []Milestone
+	// add adds the milestone unless it's already been celebrated, and
+	// reports whether it did.
+	add := func(key, event string, ctx map[string]string) bool {
+		if u.Achieved(key) {
+			return false
+		}
+		tmpl, msg, err := u.renderMessage(event, ctx)
+		if err != nil {
+			log.Errorf("rendering %s for %q: %s", event, u.Username, err)
+			return false
+		}
+		found = append(found, Milestone{Key: key, Date: now, Template: tmpl, Message: msg})
+		return true
+	}
+
+	streak := u.streak()
+	for i := len(streakMilestones) - 1; i >= 0; i-- {
+		if days := streakMilestones[i]; streak >= days {
+			add(fmt.Sprintf("streak:%d", days), "milestone-streak", u.streakContext(days))
+			break
+		}
+	}
+
+	current, err := u.MovingAverageWeight(now, 5, 0)
+	if err != nil {
+		return found
+	}
+
+	if u.MilestoneStartKgs == 0 {
+		u.MilestoneStartKgs = current
+	}
+	step := u.milestoneStep()
+	if steps := math.Floor((u.MilestoneStartKgs - current) / step); steps >= 1 {
+		add(fmt.Sprintf("loss:%.0f%s", steps*step*u.unitFactor(), u.Unit()), "milestone-loss",
+			u.lossContext(u.MilestoneStartKgs, u.MilestoneStartKgs-steps*step))
+	}
+
+	if prev, err := u.MovingAverageWeight(now, 5, 1); err == nil {
+		round := math.Floor(prev*u.unitFactor()/10) * 10
+		if round == prev*u.unitFactor() {
+			round -= 10
+		}
+		if current*u.unitFactor() < round {
+			add(fmt.Sprintf("round:%.0f%s", round, u.Unit()), "milestone-round", u.roundContext(round, current))
+		}
+	}
+
+	switch {
+	case u.LowestTrend == 0:
+		u.LowestTrend = current
+		u.LowestTrendDate = now
+	case current < u.LowestTrend:
+		if now.Sub(u.LastLowMilestone) >= lowMilestoneGap &&
+			add(fmt.Sprintf("low:%s", now.Format("2006-01-02")), "milestone-low", u.lowContext(current)) {
+			u.LastLowMilestone = now
+		}
+		u.LowestTrend = current
+		u.LowestTrendDate = now
+	}
+
+	if u.HeightCm > 0 {
+		bmi := current / math.Pow(u.HeightCm/100, 2)
+		category := bmiCategories[bmiCategory(bmi)]
+		for _, previous := range bmiCategories {
+			if previous.Name == u.BmiCategory && category.Distance < previous.Distance {
+				add("bmi:"+category.Name, "milestone-bmi", bmiContext(bmi, previous.Name, category.Name))
+			}
+		}
+		u.BmiCategory = category.Name
+	}
+
+	return found
+}
+
+// The milestone templates are rendered with these contexts.
+
+func (u *User) streakContext(days int) map[string]string {
+	return map[string]string{"days": Messages.Days(u.Locale(), days)}
+}
+
+// lossContext describes a loss from start to final, rounded to the milestone
+// passed.
+func (u *User) lossContext(start, final float64) map[string]string {
+	return map[string]string{
+		"delta": u.FormatKg(start - final),
+		"start": u.FormatKg(start),
+		"final": u.FormatKg(final),
+		"unit":  u.Unit(),
+	}
+}
+
+// roundContext describes the trend dipping under round, in the user's unit.
+func (u *User) roundContext(round, final float64) map[string]string {
+	return map[string]string{
+		"round": fmt.Sprintf("%.0f", round),
+		"final": u.FormatKg(final),
+		"unit":  u.Unit(),
+	}
+}
+
+func (u *User) lowContext(final float64) map[string]string {
+	return map[string]string{
+		"final": u.FormatKg(final),
+		"unit":  u.Unit(),
+	}
+}
+
+func bmiContext(bmi float64, from, to string) map[string]string {
+	return map[string]string{
+		"bmi":  fmt.Sprintf("%.1f", bmi),
+		"from": from,
+		"to":   to,
+	}
+}
+
+// unitFactor converts kilograms to the user's preferred unit.
+func (u *User) unitFactor() float64 {
+	if u.Kgs {
+		return 1
+	}
+	return PoundsFromKg
+}
+
+// celebrate renders a message for each milestone the user has newly reached,
+// and records them on the user so each is celebrated only once; the caller
+// saves the user.
+func (u *User) celebrate(now time.Time) []Notification {
+	milestones := u.newMilestones(now)
+	var notes []Notification
+	for _, m := range milestones {
+		log.Infof("%q reached milestone %s", u.Username, m.Key)
+		notes = append(notes, Notification{Kind: "milestone", Template: m.Template, Body: m.Message})
+	}
+	u.Milestones = append(u.Milestones, milestones...)
+	return notes
+}
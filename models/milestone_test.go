@@ -0,0 +1,35 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLowMilestoneSteadyLoss(t *testing.T) {
+	start := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	// A new low trend every day, too slowly to pass any other milestone.
+	u := &User{Username: "steady", Kgs: true}
+	for day := 0; day < 20; day++ {
+		u.Weights = append(u.Weights, Weight{Date: clock.Now(), Kgs: 95 - 0.1*float64(day)})
+		u.celebrate(clock.Now())
+		clock.Advance(24 * time.Hour)
+	}
+	var lows []time.Time
+	for _, m := range u.Milestones {
+		if strings.HasPrefix(m.Key, "low:") {
+			lows = append(lows, m.Date)
+		}
+	}
+
+	if len(lows) < 2 {
+		t.Fatalf("got low milestones at %v over 20 days of steady loss, want at least 2", lows)
+	}
+	for i := 1; i < len(lows); i++ {
+		if gap := lows[i].Sub(lows[i-1]); gap < lowMilestoneGap {
+			t.Errorf("low milestones at %s and %s are only %s apart", lows[i-1], lows[i], gap)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/metrics"
+	errors2 "github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// Notifier delivers messages to users over whichever channels they have set
+// up. Messages are queued in the outbox and delivered by Run, so a failed send
+// is retried rather than lost. Either channel may be nil if it is not
+// configured.
+//
+// In dry-run mode, either for everyone or for users with DryRun set, messages
+// are logged and stored in the outbox but never delivered.
+//
+// Clock decides when messages are queued and delivered, and when the
+// summaries, reports and reminders it sends are for.
+type Notifier struct {
+	Db       *bbolt.DB
+	Twilio   *Twilio
+	Telegram *Telegram
+	DryRun   bool
+	Clock    Clock
+
+	wake       chan struct{}
+	background sync.WaitGroup
+}
+
+// notifierLog is the logger for the notifier subsystem, which queues and
+// delivers messages.
+var notifierLog = New("notifier")
+
+func NewNotifier(db *bbolt.DB, twilio *Twilio, telegram *Telegram) *Notifier {
+	return &Notifier{
+		Db:       db,
+		Twilio:   twilio,
+		Telegram: telegram,
+		Clock:    WallClock,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Go runs f, e.g. a toast, in the background, such that Wait waits for it to
+// finish.
+func (n *Notifier) Go(f func()) {
+	n.background.Add(1)
+	go func() {
+		defer n.background.Done()
+		f()
+	}()
+}
+
+// Wait waits for everything started by Go to finish, so that their messages
+// are queued before the database is closed.
+func (n *Notifier) Wait() {
+	n.background.Wait()
+}
+
+// Notification is a message for a user, along with what it is, e.g. "toast"
+// or "summary", and the template it was rendered from, if any.
+type Notification struct {
+	Kind     string
+	Template string
+	Body     string
+}
+
+// Notify queues a notification for delivery over every channel the user has
+// configured. An error is returned if the user has no channels or the message
+// could not be queued.
+func (n *Notifier) Notify(u *User, note Notification) error {
+	if n == nil {
+		return errors.New("no notifier configured, cannot notify")
+	}
+
+	var channels []string
+	if u.Phone != "" && u.PhoneVerified && u.SmsAllowed() {
+		channels = append(channels, ChannelSms)
+	}
+	if u.TelegramChatId != 0 {
+		channels = append(channels, ChannelTelegram)
+	}
+	dryRun := n.DryRun || u.DryRun
+	if len(channels) == 0 && dryRun {
+		channels = append(channels, ChannelNone)
+	}
+	if len(channels) == 0 {
+		return fmt.Errorf("user %q has no notification channels configured", u.Username)
+	}
+
+	if dryRun {
+		var msgs []*OutboundMessage
+		for _, channel := range channels {
+			notifierLog.Infof("dry run: not sending %s to %q via %s: %q", note.Kind, u.Username, channel, note.Body)
+			metrics.Messages.WithLabelValues(note.Kind, channel, "dry_run").Inc()
+			msgs = append(msgs, &OutboundMessage{
+				Username: u.Username,
+				Channel:  channel,
+				Kind:     note.Kind,
+				Template: note.Template,
+				Body:     note.Body,
+				Status:   MessageDryRun,
+				Created:  n.Clock.Now(),
+			})
+		}
+		if err := enqueue(n.Db, msgs...); err != nil {
+			return fmt.Errorf("storing dry-run %s for %q: %w", note.Kind, u.Username, err)
+		}
+		return nil
+	}
+
+	now := n.Clock.Now()
+	next := now
+	if quiet, end := u.QuietUntil(next); quiet {
+		notifierLog.Debugf("%q is in quiet hours; holding %s until %s", u.Username, note.Kind, end)
+		next = end
+	}
+
+	var msgs []*OutboundMessage
+	for _, channel := range channels {
+		msgs = append(msgs, &OutboundMessage{
+			Username:    u.Username,
+			Channel:     channel,
+			Kind:        note.Kind,
+			Template:    note.Template,
+			Body:        note.Body,
+			Status:      MessagePending,
+			NextAttempt: next,
+			Created:     now,
+		})
+	}
+	if err := enqueue(n.Db, msgs...); err != nil {
+		return fmt.Errorf("queueing %s for %q: %w", note.Kind, u.Username, err)
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (u *User) sendSms(twilio *Twilio, message string) (string, error) {
+	if u.Phone == "" {
+		return "", fmt.Errorf("%w: user %q has no registered phone", Undeliverable, u.Username)
+	}
+
+	if !u.PhoneVerified {
+		return "", fmt.Errorf("%w: user %q has not verified their phone", Undeliverable, u.Username)
+	}
+
+	if !u.SmsAllowed() {
+		return "", fmt.Errorf("%w: user %q has opted out of SMS", Undeliverable, u.Username)
+	}
+
+	if twilio == nil {
+		return "", errors.New("twilio mis- or un-configured, cannot toast")
+	}
+
+	sid, err := twilio.SendSms(u.Phone, message)
+	if err != nil {
+		return "", errors2.WithMessagef(err, "sending toast to %s at %s", u.Username, u.Phone)
+	}
+
+	return sid, nil
+}
+
+func (u *User) sendTelegram(telegram *Telegram, message string) (string, error) {
+	if u.TelegramChatId == 0 {
+		return "", fmt.Errorf("%w: user %q has no linked telegram chat", Undeliverable, u.Username)
+	}
+
+	if telegram == nil {
+		return "", errors.New("telegram mis- or un-configured, cannot send")
+	}
+
+	id, err := telegram.SendMessage(u.TelegramChatId, message)
+	if err != nil {
+		return "", errors2.WithMessagef(err, "sending telegram to %s", u.Username)
+	}
+
+	return id, nil
+}
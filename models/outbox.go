@@ -0,0 +1,595 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/asymmetricia/vator/metrics"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	OutboxBucket = "outbox"
+	// OutboxProviderBucket maps provider message IDs to outbox IDs, so that
+	// delivery status callbacks can find the message they refer to.
+	OutboxProviderBucket = "outbox-provider"
+	// OutboxPendingBucket indexes pending messages by ID, and
+	// OutboxSentBucket indexes sent messages by when they were sent, so that
+	// delivery needn't read through the whole history.
+	OutboxPendingBucket = "outbox-pending"
+	OutboxSentBucket    = "outbox-sent"
+)
+
+const (
+	// OutboxMaxAttempts is how many times delivery of a message is tried
+	// before it is dead-lettered.
+	OutboxMaxAttempts = 8
+	outboxBaseBackoff = time.Minute
+	outboxMaxBackoff  = 6 * time.Hour
+)
+
+type MessageStatus string
+
+const (
+	MessagePending    MessageStatus = "pending"
+	MessageSent       MessageStatus = "sent"
+	MessageDead       MessageStatus = "dead"
+	MessageSuperseded MessageStatus = "superseded"
+
+	// MessageDryRun messages were rendered in dry-run mode and are only kept
+	// for inspection; they are never delivered.
+	MessageDryRun MessageStatus = "dry-run"
+)
+
+const (
+	ChannelSms      = "sms"
+	ChannelTelegram = "telegram"
+
+	// ChannelNone is recorded for dry-run messages to users with no channels.
+	ChannelNone = "none"
+)
+
+// Undeliverable is wrapped by delivery errors that retrying cannot fix, such
+// as the user having opted out; messages failing this way are dead-lettered
+// immediately.
+var Undeliverable = errors.New("undeliverable")
+
+// errDryRun is returned by deliver for messages that are due while dry-run
+// mode is on, for everyone or for their user; they're marked MessageDryRun
+// rather than sent.
+var errDryRun = errors.New("dry run")
+
+// OutboundMessage is a notification waiting in, or delivered from, the
+// outbox. Each channel a message is sent over gets its own record, so that
+// each is retried independently. Records are kept after delivery as the
+// user's notification history.
+type OutboundMessage struct {
+	Id          uint64
+	Username    string
+	Channel     string
+	Kind        string
+	Template    string
+	Body        string
+	Status      MessageStatus
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Created     time.Time
+	Sent        time.Time
+
+	// ProviderId is the ID Twilio or Telegram assigned the message, and
+	// DeliveryStatus is the latest status the provider reported for it.
+	ProviderId     string
+	DeliveryStatus string
+
+	// Direct messages, like replies and verification codes, were sent
+	// directly rather than through the outbox, and are only recorded as
+	// history; their bodies may be masked, so they're never replayed.
+	Direct bool
+}
+
+func outboxKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func enqueue(db *bbolt.DB, msgs ...*OutboundMessage) error {
+	return metrics.Update(db, "outbox.enqueue", func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(OutboxBucket))
+		if err != nil {
+			return fmt.Errorf("opening %s bucket: %s", OutboxBucket, err)
+		}
+		for _, m := range msgs {
+			if m.Status == MessagePending && coalescedKinds[m.Kind] {
+				if err := supersede(tx, m); err != nil {
+					return err
+				}
+			}
+			if m.Id, err = b.NextSequence(); err != nil {
+				return fmt.Errorf("allocating message ID: %s", err)
+			}
+			if err := putMessage(tx, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// supersede marks any pending messages that m makes redundant, i.e. those of a
+// coalesced kind for the same user and channel, as superseded.
+func supersede(tx *bbolt.Tx, m *OutboundMessage) error {
+	var superseded []*OutboundMessage
+	err := eachPending(tx, func(old *OutboundMessage) {
+		if coalescedKinds[old.Kind] && old.Username == m.Username && old.Channel == m.Channel {
+			superseded = append(superseded, old)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, old := range superseded {
+		notifierLog.Debugf("message %d to %q superseded by newer %s", old.Id, old.Username, m.Kind)
+		old.Status = MessageSuperseded
+		if err := putMessage(tx, old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putMessage(tx *bbolt.Tx, m *OutboundMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshalling message into JSON: %s", err)
+	}
+	key := outboxKey(m.Id)
+	if err := tx.Bucket([]byte(OutboxBucket)).Put(key, data); err != nil {
+		return err
+	}
+	if err := indexMessage(tx, m); err != nil {
+		return err
+	}
+
+	if m.ProviderId == "" {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(OutboxProviderBucket))
+	if err != nil {
+		return fmt.Errorf("opening %s bucket: %s", OutboxProviderBucket, err)
+	}
+	return b.Put([]byte(m.Channel+":"+m.ProviderId), outboxKey(m.Id))
+}
+
+// sentKey is m's key in OutboxSentBucket: when it was sent, then its ID.
+func sentKey(m *OutboundMessage) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(m.Sent.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], m.Id)
+	return key
+}
+
+// indexMessage adds m to, or removes it from, the pending index according to
+// its status, and adds it to the sent index if it's been sent. A message sent
+// more than once, by a replay, is indexed each time it was sent.
+func indexMessage(tx *bbolt.Tx, m *OutboundMessage) error {
+	pending, err := tx.CreateBucketIfNotExists([]byte(OutboxPendingBucket))
+	if err != nil {
+		return fmt.Errorf("opening %s bucket: %s", OutboxPendingBucket, err)
+	}
+	if m.Status == MessagePending {
+		err = pending.Put(outboxKey(m.Id), []byte{})
+	} else {
+		err = pending.Delete(outboxKey(m.Id))
+	}
+	if err != nil {
+		return err
+	}
+
+	if m.Status != MessageSent || m.Sent.IsZero() {
+		return nil
+	}
+	sent, err := tx.CreateBucketIfNotExists([]byte(OutboxSentBucket))
+	if err != nil {
+		return fmt.Errorf("opening %s bucket: %s", OutboxSentBucket, err)
+	}
+	return sent.Put(sentKey(m), outboxKey(m.Id))
+}
+
+// loadMessage returns the message the outbox holds under key, or nil if there
+// isn't one, e.g. for a stale index entry.
+func loadMessage(tx *bbolt.Tx, key []byte) *OutboundMessage {
+	data := tx.Bucket([]byte(OutboxBucket)).Get(key)
+	if data == nil {
+		return nil
+	}
+	m := &OutboundMessage{}
+	if err := json.Unmarshal(data, m); err != nil {
+		notifierLog.Warningf("skipping malformed message %x: %q", key, string(data))
+		return nil
+	}
+	return m
+}
+
+// eachPending calls fn with each pending message, in the order they were
+// queued.
+func eachPending(tx *bbolt.Tx, fn func(m *OutboundMessage)) error {
+	b := tx.Bucket([]byte(OutboxPendingBucket))
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, _ []byte) error {
+		if m := loadMessage(tx, k); m != nil && m.Status == MessagePending {
+			fn(m)
+		}
+		return nil
+	})
+}
+
+// countSent returns how many messages were sent to username over channel
+// since the given time.
+func countSent(db *bbolt.DB, username, channel string, since time.Time) (int, error) {
+	var count int
+	err := metrics.View(db, "outbox.count_sent", func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(OutboxSentBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(sentKey(&OutboundMessage{Sent: since})); k != nil; k, v = c.Next() {
+			m := loadMessage(tx, v)
+			// Messages sent again since are counted under the later time.
+			if m == nil || m.Status != MessageSent || !bytes.Equal(k, sentKey(m)) {
+				continue
+			}
+			if m.Username == username && m.Channel == channel {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// TidyOutbox rebuilds the outbox's pending index, and fills in the sent index,
+// e.g. for databases that predate them.
+func TidyOutbox(db *bbolt.DB) {
+	err := metrics.Update(db, "outbox.tidy", func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(OutboxBucket))
+		if b == nil {
+			return nil
+		}
+		if err := tx.DeleteBucket([]byte(OutboxPendingBucket)); err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+			return err
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			if m := loadMessage(tx, k); m != nil {
+				return indexMessage(tx, m)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		notifierLog.Errorf("indexing outbox: %s", err)
+	}
+}
+
+// RecordSent adds a message that was sent directly, rather than through the
+// outbox, to the user's notification history. sendErr is the error, if any,
+// from sending it.
+func RecordSent(db *bbolt.DB, username, channel string, note Notification, providerId string, sendErr error) {
+	m := &OutboundMessage{
+		Username:   username,
+		Channel:    channel,
+		Kind:       note.Kind,
+		Template:   note.Template,
+		Body:       note.Body,
+		Status:     MessageSent,
+		Attempts:   1,
+		Created:    time.Now(),
+		Sent:       time.Now(),
+		ProviderId: providerId,
+		Direct:     true,
+	}
+	if sendErr != nil {
+		m.Status = MessageDead
+		m.Sent = time.Time{}
+		m.LastError = sendErr.Error()
+	}
+	metrics.Messages.WithLabelValues(note.Kind, channel, string(m.Status)).Inc()
+	if err := enqueue(db, m); err != nil {
+		notifierLog.Errorf("recording %s sent to %q: %s", note.Kind, username, err)
+	}
+}
+
+// UpdateDeliveryStatus records the delivery status reported by a provider for
+// the message it knows as providerId.
+func UpdateDeliveryStatus(db *bbolt.DB, channel, providerId, status, errorCode string) error {
+	return metrics.Update(db, "outbox.delivery_status", func(tx *bbolt.Tx) error {
+		var key []byte
+		if b := tx.Bucket([]byte(OutboxProviderBucket)); b != nil {
+			key = b.Get([]byte(channel + ":" + providerId))
+		}
+		if key == nil {
+			return fmt.Errorf("no %s message with provider ID %q", channel, providerId)
+		}
+
+		data := tx.Bucket([]byte(OutboxBucket)).Get(key)
+		if data == nil {
+			return fmt.Errorf("%s message %q is indexed but missing", channel, providerId)
+		}
+		m := &OutboundMessage{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return fmt.Errorf("message %x corrupt: %s", key, err)
+		}
+
+		m.DeliveryStatus = status
+		if errorCode != "" {
+			m.LastError = fmt.Sprintf("%s reported error %s", channel, errorCode)
+		}
+		return putMessage(tx, m)
+	})
+}
+
+// renameOutbox re-addresses queued and historical messages when a user is
+// renamed.
+func renameOutbox(tx *bbolt.Tx, from, to string) error {
+	b := tx.Bucket([]byte(OutboxBucket))
+	if b == nil {
+		return nil
+	}
+
+	var updates []*OutboundMessage
+	err := b.ForEach(func(k, v []byte) error {
+		m := &OutboundMessage{}
+		if err := json.Unmarshal(v, m); err != nil || m.Username != from {
+			return nil
+		}
+		m.Username = to
+		updates = append(updates, m)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range updates {
+		if err := putMessage(tx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateMessage loads the message with the given ID, passes it to update, and
+// saves the result, all in one transaction.
+func UpdateMessage(db *bbolt.DB, id uint64, update func(m *OutboundMessage) error) error {
+	return metrics.Update(db, "outbox.update", func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(OutboxBucket))
+		if b == nil {
+			return fmt.Errorf("message %d not found", id)
+		}
+		data := b.Get(outboxKey(id))
+		if data == nil {
+			return fmt.Errorf("message %d not found", id)
+		}
+		m := &OutboundMessage{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return fmt.Errorf("message %d corrupt: %s", id, err)
+		}
+		if err := update(m); err != nil {
+			return err
+		}
+		return putMessage(tx, m)
+	})
+}
+
+// ListOutbox returns every message in the outbox, oldest first, for which
+// match returns true. A nil match returns all of them.
+func ListOutbox(db *bbolt.DB, match func(m *OutboundMessage) bool) ([]*OutboundMessage, error) {
+	var msgs []*OutboundMessage
+	err := metrics.View(db, "outbox.list", func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(OutboxBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			m := &OutboundMessage{}
+			if err := json.Unmarshal(v, m); err != nil {
+				notifierLog.Warningf("skipping malformed message %x: %q", k, string(v))
+				return nil
+			}
+			if match == nil || match(m) {
+				msgs = append(msgs, m)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing %s bucket: %w", OutboxBucket, err)
+	}
+	return msgs, nil
+}
+
+// ReplayMessage puts a sent or dead-lettered message back in the queue to be
+// delivered again as soon as possible. Dry-run messages were never meant to be
+// sent, and direct messages were only recorded, so neither can be replayed.
+func ReplayMessage(db *bbolt.DB, id uint64) error {
+	return UpdateMessage(db, id, func(m *OutboundMessage) error {
+		if m.Status == MessageDryRun {
+			return fmt.Errorf("message %d was rendered in dry-run mode, and can't be replayed", id)
+		}
+		if m.Direct {
+			return fmt.Errorf("message %d was a %s sent directly, and can't be replayed", id, m.Kind)
+		}
+		m.Status = MessagePending
+		m.Attempts = 0
+		m.NextAttempt = time.Now()
+		m.LastError = ""
+		return nil
+	})
+}
+
+// backoff returns how long to wait before the next delivery attempt, after
+// the given number of failed attempts.
+func backoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxBackoff {
+		delay = outboxMaxBackoff
+	}
+	return delay
+}
+
+// Run delivers messages from the outbox as they come due, until ctx is done.
+// A delivery in progress is finished first; anything left is delivered on the
+// next run.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		next := n.DeliverDue()
+
+		wait := next.Sub(n.Clock.Now())
+		if next.IsZero() || wait > time.Minute {
+			wait = time.Minute
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-n.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// DeliverDue attempts delivery of every pending message whose time has come,
+// and returns when the next pending message will be due, or the zero time if
+// there are none.
+func (n *Notifier) DeliverDue() time.Time {
+	var pending []*OutboundMessage
+	err := metrics.View(n.Db, "outbox.pending", func(tx *bbolt.Tx) error {
+		return eachPending(tx, func(m *OutboundMessage) { pending = append(pending, m) })
+	})
+	if err != nil {
+		notifierLog.Errorf("reading outbox: %s", err)
+		return time.Time{}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].NextAttempt.Before(pending[j].NextAttempt) })
+
+	var next time.Time
+	for _, m := range pending {
+		if m.NextAttempt.After(n.Clock.Now()) {
+			if next.IsZero() || m.NextAttempt.Before(next) {
+				next = m.NextAttempt
+			}
+			continue
+		}
+
+		providerId, holdUntil, deliveryErr := n.deliver(m)
+		attempts := m.Attempts
+		var outcome string
+		err := UpdateMessage(n.Db, m.Id, func(m *OutboundMessage) error {
+			// The message may have been superseded or replayed while it was
+			// being sent; if so, that stands.
+			if m.Status != MessagePending || m.Attempts != attempts {
+				notifierLog.Warningf("message %d to %q changed to %s, attempt %d, during delivery; leaving it (delivery error: %v)",
+					m.Id, m.Username, m.Status, m.Attempts, deliveryErr)
+				return nil
+			}
+
+			if !holdUntil.IsZero() {
+				notifierLog.Debugf("holding message %d to %q until %s", m.Id, m.Username, holdUntil)
+				m.NextAttempt = holdUntil
+				if next.IsZero() || m.NextAttempt.Before(next) {
+					next = m.NextAttempt
+				}
+				return nil
+			}
+
+			if errors.Is(deliveryErr, errDryRun) {
+				outcome = "dry_run"
+				m.Status = MessageDryRun
+				notifierLog.Infof("dry run: not sending message %d to %q via %s: %q", m.Id, m.Username, m.Channel, m.Body)
+				return nil
+			}
+
+			m.Attempts++
+			switch {
+			case deliveryErr == nil:
+				outcome = "sent"
+				m.Status = MessageSent
+				m.Sent = n.Clock.Now()
+				m.LastError = ""
+				m.ProviderId = providerId
+			case errors.Is(deliveryErr, Undeliverable) || m.Attempts >= OutboxMaxAttempts:
+				outcome = "dead"
+				m.Status = MessageDead
+				m.LastError = deliveryErr.Error()
+				notifierLog.Errorf("dead-lettering message %d to %q after %d attempts: %s",
+					m.Id, m.Username, m.Attempts, deliveryErr)
+			default:
+				outcome = "retry"
+				m.NextAttempt = n.Clock.Now().Add(backoff(m.Attempts))
+				m.LastError = deliveryErr.Error()
+				notifierLog.Warningf("delivering message %d to %q failed, retrying at %s: %s",
+					m.Id, m.Username, m.NextAttempt, deliveryErr)
+				if next.IsZero() || m.NextAttempt.Before(next) {
+					next = m.NextAttempt
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			notifierLog.Errorf("updating message %d after delivery attempt: %s", m.Id, err)
+		} else if outcome != "" {
+			metrics.Messages.WithLabelValues(m.Kind, m.Channel, outcome).Inc()
+		}
+	}
+	return next
+}
+
+// deliver sends m over its channel, returning the provider's ID for it. The
+// user is re-loaded so that changes since the message was queued, like opting
+// out or new quiet hours, are respected; if the message must wait, nothing is
+// sent and the time it may be sent is returned instead. In dry-run mode,
+// nothing is sent and errDryRun is returned.
+func (n *Notifier) deliver(m *OutboundMessage) (string, time.Time, error) {
+	u, err := LoadUser(n.Db, m.Username)
+	if errors.Is(err, UserNotFound) {
+		return "", time.Time{}, fmt.Errorf("%w: %s", Undeliverable, err)
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if n.DryRun || u.DryRun {
+		return "", time.Time{}, errDryRun
+	}
+
+	if hold := n.holdUntil(u, m, n.Clock.Now()); !hold.IsZero() {
+		return "", hold, nil
+	}
+
+	var id string
+	switch m.Channel {
+	case ChannelSms:
+		id, err = u.sendSms(n.Twilio, m.Body)
+	case ChannelTelegram:
+		id, err = u.sendTelegram(n.Telegram, m.Body)
+	default:
+		err = fmt.Errorf("%w: unknown channel %q", Undeliverable, m.Channel)
+	}
+	return id, time.Time{}, err
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// fakeTelegram returns a Telegram whose API counts the messages sent through
+// it.
+func fakeTelegram(t *testing.T) (*Telegram, *atomic.Int32) {
+	t.Helper()
+	var sent atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := sent.Add(1)
+		fmt.Fprintf(rw, `{"ok":true,"result":{"message_id":%d}}`, n)
+	}))
+	t.Cleanup(srv.Close)
+	return &Telegram{Token: "123:abc", BaseUrl: srv.URL, Username: "vator_bot"}, &sent
+}
+
+func TestDeliverDueDryRun(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	for _, test := range []struct {
+		name                 string
+		notifierDry, userDry bool
+	}{
+		{name: "for everyone", notifierDry: true},
+		{name: "for the user", userDry: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db := testDb(t)
+			telegram, sent := fakeTelegram(t)
+			u := &User{Username: "copied", TelegramChatId: 42, DryRun: test.userDry}
+			if err := u.Save(db); err != nil {
+				t.Fatal(err)
+			}
+
+			// A message queued before dry-run mode was turned on, e.g. in a
+			// copy of the production database.
+			m := &OutboundMessage{
+				Username:    u.Username,
+				Channel:     ChannelTelegram,
+				Kind:        "toast",
+				Body:        "nice!",
+				Status:      MessagePending,
+				NextAttempt: clock.Now(),
+				Created:     clock.Now(),
+			}
+			if err := enqueue(db, m); err != nil {
+				t.Fatal(err)
+			}
+
+			notifier := NewNotifier(db, nil, telegram)
+			notifier.Clock = clock
+			notifier.DryRun = test.notifierDry
+			if next := notifier.DeliverDue(); !next.IsZero() {
+				t.Errorf("got next delivery at %s, want none", next)
+			}
+			if n := sent.Load(); n != 0 {
+				t.Errorf("sent %d messages in dry-run mode", n)
+			}
+
+			msgs, err := ListOutbox(db, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != 1 || msgs[0].Status != MessageDryRun || msgs[0].Attempts != 0 {
+				t.Fatalf("got outbox %+v, want the message marked dry-run", msgs)
+			}
+
+			// Replaying it would send it for real once dry-run mode is off.
+			if err := ReplayMessage(db, m.Id); err == nil {
+				t.Error("replaying a dry-run message succeeded")
+			}
+			notifier.DryRun = false
+			notifier.DeliverDue()
+			if n := sent.Load(); n != 0 {
+				t.Errorf("sent %d messages after refusing to replay", n)
+			}
+		})
+	}
+}
+
+func TestDeliverDue(t *testing.T) {
+	db := testDb(t)
+	telegram, sent := fakeTelegram(t)
+	u := &User{Username: "live", TelegramChatId: 42}
+	if err := u.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := NewNotifier(db, nil, telegram)
+	if err := notifier.Notify(u, Notification{Kind: "toast", Body: "nice!"}); err != nil {
+		t.Fatal(err)
+	}
+	notifier.DeliverDue()
+	if n := sent.Load(); n != 1 {
+		t.Errorf("sent %d messages, want 1", n)
+	}
+	msgs, err := ListOutbox(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Status != MessageSent || msgs[0].ProviderId != "1" {
+		t.Errorf("got outbox %+v, want the message sent", msgs)
+	}
+}
+
+func TestTidyOutbox(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	db := testDb(t)
+	telegram, sent := fakeTelegram(t)
+	u := &User{Username: "capped", TelegramChatId: 42, DailyCap: 1}
+	if err := u.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// A message queued before the outbox was indexed.
+	m := &OutboundMessage{
+		Username:    u.Username,
+		Channel:     ChannelTelegram,
+		Kind:        "toast",
+		Body:        "nice!",
+		Status:      MessagePending,
+		NextAttempt: clock.Now(),
+		Created:     clock.Now(),
+	}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(OutboxBucket))
+		if err != nil {
+			return err
+		}
+		if m.Id, err = b.NextSequence(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return b.Put(outboxKey(m.Id), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	TidyOutbox(db)
+	notifier := NewNotifier(db, nil, telegram)
+	notifier.Clock = clock
+	notifier.DeliverDue()
+	if n := sent.Load(); n != 1 {
+		t.Fatalf("sent %d messages, want the one queued before indexing", n)
+	}
+
+	// That used up today's cap, so the next waits for tomorrow.
+	if err := notifier.Notify(u, Notification{Kind: "reminder", Body: "weigh in?"}); err != nil {
+		t.Fatal(err)
+	}
+	if next := notifier.DeliverDue(); !next.Equal(u.startOfNextDay(clock.Now())) {
+		t.Errorf("got next delivery at %s, want tomorrow", next)
+	}
+	if n := sent.Load(); n != 1 {
+		t.Errorf("sent %d messages, want 1 with a daily cap of 1", n)
+	}
+}
+
+func TestReplayDirect(t *testing.T) {
+	db := testDb(t)
+	RecordSent(db, "verifying", ChannelSms, Notification{
+		Kind: "verification",
+		Body: "Your vator verification code is ******.",
+	}, "SM123", nil)
+
+	msgs, err := ListOutbox(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !msgs[0].Direct {
+		t.Fatalf("got outbox %+v, want the code recorded as direct", msgs)
+	}
+	if err := ReplayMessage(db, msgs[0].Id); err == nil {
+		t.Error("replaying a verification code with its code masked succeeded")
+	}
+	if msgs, err := ListOutbox(db, nil); err != nil || msgs[0].Status != MessageSent {
+		t.Errorf("got outbox %+v, %v; want the code left as sent", msgs, err)
+	}
+}
+
+func TestDeliverDueSupersededInFlight(t *testing.T) {
+	db := testDb(t)
+	u := &User{Username: "busy", TelegramChatId: 42}
+	if err := u.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message is superseded while telegram is sending it.
+	var id uint64
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		err := UpdateMessage(db, id, func(m *OutboundMessage) error {
+			m.Status = MessageSuperseded
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		fmt.Fprint(rw, `{"ok":true,"result":{"message_id":1}}`)
+	}))
+	defer srv.Close()
+	telegram := &Telegram{Token: "123:abc", BaseUrl: srv.URL, Username: "vator_bot"}
+
+	notifier := NewNotifier(db, nil, telegram)
+	if err := notifier.Notify(u, Notification{Kind: "toast", Body: "nice!"}); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := ListOutbox(db, nil)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("got outbox %+v, %v; want one message", msgs, err)
+	}
+	id = msgs[0].Id
+
+	notifier.DeliverDue()
+	if msgs, err := ListOutbox(db, nil); err != nil || msgs[0].Status != MessageSuperseded {
+		t.Errorf("got outbox %+v, %v; want the message left superseded", msgs, err)
+	}
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	PhoneCodeTtl      = 10 * time.Minute
+	PhoneCodeAttempts = 5
+	PhoneCodesPerHour = 3
+)
+
+var (
+	InvalidPhone     = errors.New("that doesn't look like a phone number")
+	NoPendingPhone   = errors.New("there's no phone number waiting to be verified")
+	PhoneCodeExpired = errors.New("that code has expired; request a new one")
+	PhoneCodeWrong   = errors.New("that code wasn't right")
+	PhoneRateLimited = errors.New("too many attempts; wait a bit and try again")
+)
+
+// NormalizePhone converts phone to E.164 form, e.g. +12065550100. Numbers
+// without a leading + are assumed to be North American.
+func NormalizePhone(phone string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	international := strings.HasPrefix(phone, "+")
+
+	var digits strings.Builder
+	for _, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case strings.ContainsRune(" -.()+", r):
+		default:
+			return "", InvalidPhone
+		}
+	}
+	d := digits.String()
+
+	if !international {
+		switch {
+		case len(d) == 10:
+			d = "1" + d
+		case len(d) == 11 && d[0] == '1':
+		default:
+			return "", InvalidPhone
+		}
+	}
+
+	if len(d) < 8 || len(d) > 15 || d[0] == '0' {
+		return "", InvalidPhone
+	}
+	return "+" + d, nil
+}
+
+// StartPhoneVerification records phone as the user's pending number and texts
+// it a six-digit code. The number does not receive anything else until
+// VerifyPhone is called with that code. The code is saved, and counted against
+// PhoneCodesPerHour, before it's sent, so that concurrent requests can't send
+// more than that; if sending fails, the code is withdrawn, but still counts.
+func StartPhoneVerification(db *bbolt.DB, twilio *Twilio, username, phone string) error {
+	if twilio == nil {
+		return errors.New("twilio mis- or un-configured, cannot verify phone")
+	}
+
+	normalized, err := NormalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return fmt.Errorf("generating phone code: %s", err)
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	_, err = UpdateUser(db, username, func(u *User) error {
+		now := time.Now()
+		var recent []time.Time
+		for _, sent := range u.PhoneCodesSent {
+			if now.Sub(sent) < time.Hour {
+				recent = append(recent, sent)
+			}
+		}
+		if len(recent) >= PhoneCodesPerHour {
+			return PhoneRateLimited
+		}
+
+		u.PendingPhone = normalized
+		u.PhoneCode = code
+		u.PhoneCodeExpiry = now.Add(PhoneCodeTtl)
+		u.PhoneCodeAttempts = 0
+		u.PhoneCodesSent = append(recent, now)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := "Your vator verification code is %s. It expires in %d minutes."
+	sid, err := twilio.SendSms(normalized, fmt.Sprintf(msg, code, int(PhoneCodeTtl.Minutes())))
+	RecordSent(db, username, ChannelSms, Notification{
+		Kind: "verification",
+		Body: fmt.Sprintf(msg, "******", int(PhoneCodeTtl.Minutes())),
+	}, sid, err)
+	if err == nil {
+		return nil
+	}
+
+	_, clearErr := UpdateUser(db, username, func(u *User) error {
+		if u.PhoneCode == code {
+			u.PendingPhone = ""
+			u.PhoneCode = ""
+		}
+		return nil
+	})
+	if clearErr != nil {
+		log.Errorf("withdrawing unsent phone code for %q: %s", username, clearErr)
+	}
+	return fmt.Errorf("sending verification code to %q: %w", normalized, err)
+}
+
+// VerifyPhone checks code against the one sent by StartPhoneVerification and,
+// if it matches, makes the pending number the user's phone. Verifying a new
+// number grants SMS consent for it; re-verifying the same one leaves consent
+// as it was, so that a STOP is only undone by texting START. The caller is
+// responsible for saving, whether or not an error is returned.
+func (u *User) VerifyPhone(code string) error {
+	if u.PendingPhone == "" || u.PhoneCode == "" {
+		return NoPendingPhone
+	}
+	if u.PhoneCodeAttempts >= PhoneCodeAttempts {
+		return PhoneRateLimited
+	}
+	if time.Now().After(u.PhoneCodeExpiry) {
+		return PhoneCodeExpired
+	}
+
+	u.PhoneCodeAttempts++
+	if strings.TrimSpace(code) != u.PhoneCode {
+		return PhoneCodeWrong
+	}
+
+	changed := !u.SamePhone(u.PendingPhone)
+	u.Phone = u.PendingPhone
+	u.PhoneVerified = true
+	u.PendingPhone = ""
+	u.PhoneCode = ""
+	u.PhoneCodeAttempts = 0
+	if changed {
+		u.SetSmsConsent(SmsConsentGranted, "web: phone verified")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTwilio returns a Twilio whose API counts the messages sent through it,
+// and fails them while failing is set.
+func fakeTwilio(t *testing.T) (twilio *Twilio, sent *atomic.Int32, failing *atomic.Bool) {
+	t.Helper()
+	sent, failing = &atomic.Int32{}, &atomic.Bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if failing.Load() {
+			http.Error(rw, `{"message":"carrier says no"}`, http.StatusBadRequest)
+			return
+		}
+		sent.Add(1)
+		rw.Write([]byte(`{"sid":"SM1"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return &Twilio{Sid: "AC1", AuthToken: "twilio-token", TwilioOptions: TwilioOptions{
+		BaseUrl: srv.URL,
+		From:    "+15555550100",
+	}}, sent, failing
+}
+
+func TestStartPhoneVerificationConcurrent(t *testing.T) {
+	db := testDb(t)
+	twilio, sent, _ := fakeTwilio(t)
+	if err := (&User{Username: "eager"}).Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			StartPhoneVerification(db, twilio, "eager", "+15555550123")
+		}()
+	}
+	wg.Wait()
+
+	if n := sent.Load(); n != PhoneCodesPerHour {
+		t.Errorf("sent %d codes, want %d", n, PhoneCodesPerHour)
+	}
+	if u, err := LoadUser(db, "eager"); err != nil || len(u.PhoneCodesSent) != PhoneCodesPerHour {
+		t.Errorf("got user %+v, %v; want %d codes counted", u, err, PhoneCodesPerHour)
+	}
+}
+
+func TestStartPhoneVerificationFailed(t *testing.T) {
+	db := testDb(t)
+	twilio, _, failing := fakeTwilio(t)
+	failing.Store(true)
+	if err := (&User{Username: "typo"}).Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StartPhoneVerification(db, twilio, "typo", "+15555550123"); err == nil {
+		t.Fatal("got no error from a failed send")
+	}
+	u, err := LoadUser(db, "typo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.PhoneCode != "" || u.PendingPhone != "" || len(u.PhoneCodesSent) != 1 {
+		t.Errorf("got code %q for %q after %d sends, want the code withdrawn but counted",
+			u.PhoneCode, u.PendingPhone, len(u.PhoneCodesSent))
+	}
+}
+
+func TestVerifyPhoneKeepsConsent(t *testing.T) {
+	for _, test := range []struct {
+		name, pending string
+		want          SmsConsent
+	}{
+		{name: "same number", pending: "+15555550123", want: SmsConsentRevoked},
+		{name: "new number", pending: "+15555550199", want: SmsConsentGranted},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			u := &User{Username: "stopped", Phone: "+15555550123", PhoneVerified: true}
+			u.SetSmsConsent(SmsConsentRevoked, "sms: STOP")
+			u.PendingPhone = test.pending
+			u.PhoneCode = "123456"
+			u.PhoneCodeExpiry = time.Now().Add(PhoneCodeTtl)
+
+			if err := u.VerifyPhone("123456"); err != nil {
+				t.Fatal(err)
+			}
+			if u.SmsConsent != test.want {
+				t.Errorf("got consent %s, want %s", u.SmsConsent, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+)
+
+// coalescedKinds are the kinds of notification that are only worth sending
+// once: if a newer one is queued before an older one is delivered, only the
+// newer one is sent.
+var coalescedKinds = map[string]bool{
+	"toast":           true,
+	"encouragement":   true,
+	"not-enough-data": true,
+	"reminder":        true,
+}
+
+// QuietUntil reports whether t falls within the user's quiet hours and, if it
+// does, when they end. Quiet hours run from QuietStart to QuietEnd, local
+// time, wrapping past midnight if QuietStart is later; they are disabled if
+// the two are equal.
+func (u *User) QuietUntil(t time.Time) (bool, time.Time) {
+	if u.QuietStart == u.QuietEnd {
+		return false, time.Time{}
+	}
+
+	local := t.In(u.Timezone())
+	hour := local.Hour()
+
+	var quiet bool
+	if u.QuietStart < u.QuietEnd {
+		quiet = hour >= u.QuietStart && hour < u.QuietEnd
+	} else {
+		quiet = hour >= u.QuietStart || hour < u.QuietEnd
+	}
+	if !quiet {
+		return false, time.Time{}
+	}
+
+	end := time.Date(local.Year(), local.Month(), local.Day(), u.QuietEnd, 0, 0, 0, local.Location())
+	if !end.After(local) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return true, end
+}
+
+// startOfNextDay returns local midnight following t in the user's time zone.
+func (u *User) startOfNextDay(t time.Time) time.Time {
+	local := t.In(u.Timezone())
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, local.Location())
+}
+
+// holdUntil returns when m may next be sent to u, or the zero time if it may
+// be sent now. Messages are held through quiet hours, and once DailyCap
+// messages have gone out on m's channel today, until tomorrow.
+func (n *Notifier) holdUntil(u *User, m *OutboundMessage, now time.Time) time.Time {
+	if quiet, end := u.QuietUntil(now); quiet {
+		return end
+	}
+
+	if u.DailyCap <= 0 {
+		return time.Time{}
+	}
+
+	local := now.In(u.Timezone())
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	sent, err := countSent(n.Db, m.Username, m.Channel, midnight)
+	if err != nil {
+		log.Errorf("counting messages sent to %q today: %s", u.Username, err)
+		return time.Time{}
+	}
+	if sent < u.DailyCap {
+		return time.Time{}
+	}
+
+	next := u.startOfNextDay(now)
+	if quiet, end := u.QuietUntil(next); quiet {
+		next = end
+	}
+	return next
+}
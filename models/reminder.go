@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ReminderLimit is how many reminders are sent without a weigh-in before vator
+// gives up until the user's next one.
+const ReminderLimit = 4
+
+// lastWeighIn is when the user last weighed in, either on their scale or by
+// logging a weight by hand.
+func (u *User) lastWeighIn() time.Time {
+	last := u.LastWeight
+	for _, w := range u.Weights {
+		if w.Date.After(last) {
+			last = w.Date
+		}
+	}
+	return last
+}
+
+// remindersSent is how many reminders have been sent since the user's last
+// weigh-in.
+func (u *User) remindersSent() int {
+	if u.LastReminder.Before(u.lastWeighIn()) {
+		return 0
+	}
+	return u.RemindersSent
+}
+
+// NextReminder returns when the user should next be reminded to weigh in, or
+// the zero time if they should not be. The first reminder is due ReminderDays
+// after the last weigh-in, and each after that waits twice as long as the one
+// before, up to ReminderLimit.
+func (u *User) NextReminder() time.Time {
+	last := u.lastWeighIn()
+	if u.ReminderDays <= 0 || last.IsZero() {
+		return time.Time{}
+	}
+
+	sent := u.remindersSent()
+	if sent >= ReminderLimit {
+		return time.Time{}
+	}
+	if sent == 0 {
+		return last.AddDate(0, 0, u.ReminderDays)
+	}
+	return u.LastReminder.AddDate(0, 0, u.ReminderDays<<sent)
+}
+
+// reminderContext is what reminder templates are rendered with.
+func (u *User) reminderContext(days int) map[string]string {
+	return map[string]string{"days": Messages.Days(u.Locale(), days)}
+}
+
+// Remind nudges the user to weigh in, rotating through the reminder templates,
+// and records that it did so. Nothing is sent if the user has paused
+// notifications.
+func (u *User) Remind(notifier *Notifier, db *bbolt.DB) {
+	if u.Paused {
+		return
+	}
+
+	now := notifier.Clock.Now()
+	days := int(now.Sub(u.lastWeighIn()).Hours() / 24)
+
+	tmpl, msg, err := u.renderMessage("reminder", u.reminderContext(days))
+	if err != nil {
+		log.Errorf("rendering reminder for %q: %s", u.Username, err)
+		return
+	}
+
+	log.Infof("reminding %q to weigh in after %d days", u.Username, days)
+	sendErr := notifier.Notify(u, Notification{Kind: "reminder", Template: tmpl, Body: msg})
+	if sendErr != nil {
+		log.Errorf("failed sending reminder to %q: %v", u.Username, sendErr)
+	}
+
+	// A reminder that couldn't be sent, e.g. to a user with no channels, still
+	// counts, so that it backs off like any other rather than being retried
+	// every time the scheduler wakes.
+	updated, err := UpdateUser(db, u.Username, func(stored *User) error {
+		stored.RemindersSent = stored.remindersSent() + 1
+		stored.LastReminder = now
+		if sendErr == nil {
+			stored.rememberTemplate(tmpl)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("failed to record reminder for %q: %v", u.Username, err)
+		return
+	}
+	u.RemindersSent, u.LastReminder = updated.RemindersSent, updated.LastReminder
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemindWithoutChannels(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	db := testDb(t)
+	u := &User{Username: "unreachable", ReminderDays: 3, LastWeight: clock.Now().AddDate(0, 0, -5)}
+	if err := u.Save(db); err != nil {
+		t.Fatal(err)
+	}
+	if due := u.NextReminder(); due.After(clock.Now()) {
+		t.Fatalf("got first reminder due at %s, want it due now", due)
+	}
+
+	notifier := NewNotifier(db, nil, nil)
+	notifier.Clock = clock
+	u.Remind(notifier, db)
+	if due := u.NextReminder(); !due.After(clock.Now()) {
+		t.Errorf("got next reminder due at %s after failing to send one, want it backed off", due)
+	}
+	if stored, err := LoadUser(db, u.Username); err != nil || !stored.LastReminder.Equal(clock.Now()) {
+		t.Errorf("got stored user %+v, %v; want the failed reminder recorded", stored, err)
+	}
+}
@@ -0,0 +1,359 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+type ReportPeriod string
+
+const (
+	ReportMonth ReportPeriod = "month"
+	ReportYear  ReportPeriod = "year"
+)
+
+var InvalidReportPeriod = errors.New("report period must be month or year")
+
+// ParseReportPeriod interprets s, e.g. from a query string, as a period.
+func ParseReportPeriod(s string) (ReportPeriod, error) {
+	switch p := ReportPeriod(strings.ToLower(s)); p {
+	case ReportMonth, ReportYear:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w, not %q", InvalidReportPeriod, s)
+}
+
+// layout is how a period's start date is written in report URLs.
+func (p ReportPeriod) layout() string {
+	if p == ReportYear {
+		return "2006"
+	}
+	return "2006-01"
+}
+
+// Add returns the start of the period n periods after the one that starts at
+// start; n may be negative.
+func (p ReportPeriod) Add(start time.Time, n int) time.Time {
+	if p == ReportYear {
+		return start.AddDate(n, 0, 0)
+	}
+	return start.AddDate(0, n, 0)
+}
+
+// reportTrendDays is the window of the trend weights a report compares.
+const reportTrendDays = 5
+
+// DayWeight is the mean of the weigh-ins on a single local day.
+type DayWeight struct {
+	Date time.Time
+	Kgs  float64
+}
+
+// Report is a retrospective over a calendar month or year. Days are the
+// user's local days; if the period is still in progress, it covers only the
+// days so far.
+type Report struct {
+	Period ReportPeriod
+	Start  time.Time
+	End    time.Time
+
+	// HasTrend is false if there weren't enough weigh-ins in the period to
+	// establish a starting and ending trend, in which case neither is set.
+	HasTrend   bool
+	StartTrend DayWeight
+	EndTrend   DayWeight
+
+	Lowest  DayWeight
+	Highest DayWeight
+
+	Days          int
+	WeighInDays   int
+	LongestStreak int
+
+	// BestWeek is the Monday starting the week with the biggest drop in
+	// trend weight, if any week saw a drop.
+	HasBestWeek    bool
+	BestWeek       time.Time
+	BestWeekChange float64
+}
+
+// Change is how much the trend weight moved over the period; negative is a
+// loss.
+func (r *Report) Change() float64 {
+	return r.EndTrend.Kgs - r.StartTrend.Kgs
+}
+
+// Consistency is the percentage of days in the period with a weigh-in.
+func (r *Report) Consistency() float64 {
+	if r.Days == 0 {
+		return 0
+	}
+	return 100 * float64(r.WeighInDays) / float64(r.Days)
+}
+
+// Title names the period covered, e.g. "September 2026".
+func (r *Report) Title() string {
+	if r.Period == ReportYear {
+		return r.Start.Format("2006")
+	}
+	return r.Start.Format("January 2006")
+}
+
+// DateParam is the period's start as it appears in report URLs.
+func (r *Report) DateParam() string {
+	return r.Start.Format(r.Period.layout())
+}
+
+// Url is where the report can be viewed, relative to baseUrl.
+func (r *Report) Url(baseUrl string) string {
+	return ReportUrl(baseUrl, r.Period, r.Start)
+}
+
+// ReportUrl is where the report for the period starting at start can be
+// viewed, relative to baseUrl, without computing the report itself.
+func ReportUrl(baseUrl string, period ReportPeriod, start time.Time) string {
+	return baseUrl + "report?" + url.Values{
+		"period": {string(period)},
+		"date":   {start.Format(period.layout())},
+	}.Encode()
+}
+
+// ParseReportDate interprets s as the start of a period in the user's time
+// zone, as written by DateParam. An empty s means the period containing now.
+func (u *User) ParseReportDate(period ReportPeriod, s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return now, nil
+	}
+	t, err := time.ParseInLocation(period.layout(), s, u.Timezone())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a valid %s", s, period)
+	}
+	return t, nil
+}
+
+// Report computes the user's report for the period containing at, as of now;
+// a period still in progress only counts the days up to now.
+func (u *User) Report(period ReportPeriod, at, now time.Time) *Report {
+	r := &Report{Period: period, Start: u.periodStart(period, at)}
+	r.End = period.Add(r.Start, 1)
+
+	last := r.End
+	if now.Before(last) {
+		last = u.startOfNextDay(now)
+	}
+
+	daily := u.dailyWeights()
+	streak := 0
+	for day := r.Start; day.Before(last); day = day.AddDate(0, 0, 1) {
+		r.Days++
+		kgs, ok := daily[dayKey(day)]
+		if !ok {
+			streak = 0
+			continue
+		}
+
+		r.WeighInDays++
+		if streak++; streak > r.LongestStreak {
+			r.LongestStreak = streak
+		}
+		if r.Lowest.Date.IsZero() || kgs < r.Lowest.Kgs {
+			r.Lowest = DayWeight{day, kgs}
+		}
+		if r.Highest.Date.IsZero() || kgs > r.Highest.Kgs {
+			r.Highest = DayWeight{day, kgs}
+		}
+
+		if trend, ok := trendAt(daily, day); ok {
+			if !r.HasTrend {
+				r.HasTrend = true
+				r.StartTrend = DayWeight{day, trend}
+			}
+			r.EndTrend = DayWeight{day, trend}
+		}
+	}
+
+	// Weeks start on Monday; the first may begin before the period does, but
+	// its trend is still measured from the day before it.
+	week := r.Start.AddDate(0, 0, -((int(r.Start.Weekday()) + 6) % 7))
+	for ; week.Before(last); week = week.AddDate(0, 0, 7) {
+		before, ok := trendAt(daily, week.AddDate(0, 0, -1))
+		if !ok {
+			continue
+		}
+		end := week.AddDate(0, 0, 6)
+		if !end.Before(last) {
+			end = last.AddDate(0, 0, -1)
+		}
+		after, ok := trendAt(daily, end)
+		if !ok {
+			continue
+		}
+		if change := after - before; change < 0 && (!r.HasBestWeek || change < r.BestWeekChange) {
+			r.HasBestWeek = true
+			r.BestWeek = week
+			r.BestWeekChange = change
+		}
+	}
+
+	return r
+}
+
+// periodStart returns local midnight at the start of the period containing at.
+func (u *User) periodStart(period ReportPeriod, at time.Time) time.Time {
+	local := at.In(u.Timezone())
+	if period == ReportYear {
+		return time.Date(local.Year(), 1, 1, 0, 0, 0, 0, local.Location())
+	}
+	return time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, local.Location())
+}
+
+// dayKey identifies the calendar day of t, in t's location.
+func dayKey(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// dailyWeights maps each local day the user weighed in, by dayKey, to the mean
+// of that day's weigh-ins.
+func (u *User) dailyWeights() map[string]float64 {
+	tz := u.Timezone()
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for _, w := range u.Weights {
+		day := dayKey(w.Date.In(tz))
+		sums[day] += w.Kgs
+		counts[day]++
+	}
+	for day, sum := range sums {
+		sums[day] = sum / float64(counts[day])
+	}
+	return sums
+}
+
+// trendAt is the moving average of the daily weights over the
+// reportTrendDays ending on day. Like MovingAverageWeight, it requires 60% of
+// the days in the window to have a weigh-in.
+func trendAt(daily map[string]float64, day time.Time) (float64, bool) {
+	var sum float64
+	var n int
+	for i := 0; i < reportTrendDays; i++ {
+		if kgs, ok := daily[dayKey(day.AddDate(0, 0, -i))]; ok {
+			sum += kgs
+			n++
+		}
+	}
+	if n == 0 || n < reportTrendDays*6/10 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// ReportMessage renders r as a notification, with a link to the full report
+// if baseUrl is set.
+func (u *User) ReportMessage(r *Report, baseUrl string) string {
+	lines := []string{r.Title() + " in review:"}
+
+	if r.HasTrend {
+		direction := "down"
+		if r.Change() > 0 {
+			direction = "up"
+		}
+		lines = append(lines, fmt.Sprintf("Trend: %s%s to %s%s, %s %s%s",
+			u.FormatKg(r.StartTrend.Kgs), u.Unit(),
+			u.FormatKg(r.EndTrend.Kgs), u.Unit(),
+			direction, u.FormatKg(math.Abs(r.Change())), u.Unit()))
+	} else {
+		lines = append(lines, "Trend: insufficient data :(")
+	}
+
+	if r.WeighInDays > 0 {
+		lines = append(lines,
+			fmt.Sprintf("Lowest day: %s, %s%s", r.Lowest.Date.Format("Jan 2"), u.FormatKg(r.Lowest.Kgs), u.Unit()),
+			fmt.Sprintf("Highest day: %s, %s%s", r.Highest.Date.Format("Jan 2"), u.FormatKg(r.Highest.Kgs), u.Unit()))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("Weighed in %d of %d days (%.0f%%)", r.WeighInDays, r.Days, r.Consistency()),
+		fmt.Sprintf("Longest streak: %d days", r.LongestStreak))
+
+	if r.HasBestWeek {
+		lines = append(lines, fmt.Sprintf("Best week: %s, down %s%s",
+			r.BestWeek.Format("Jan 2"), u.FormatKg(-r.BestWeekChange), u.Unit()))
+	}
+
+	if baseUrl != "" {
+		lines = append(lines, "Full report: "+r.Url(baseUrl))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// NextReport returns when the user's next report for period is due, which
+// may be before now if it is overdue, or the zero time if they have turned
+// reports off. Reports go out at SummaryHour on the first day of the
+// following period, local time, even if summaries are off.
+func (u *User) NextReport(period ReportPeriod, now time.Time) time.Time {
+	if u.NoReports {
+		return time.Time{}
+	}
+
+	base := u.LastMonthlyReport
+	if period == ReportYear {
+		base = u.LastYearlyReport
+	}
+	if floor := now.Add(-summaryCatchUp); base.Before(floor) {
+		base = floor
+	}
+	local := base.In(u.Timezone())
+
+	if period == ReportYear {
+		next := time.Date(local.Year(), 1, 1, u.SummaryHour, 0, 0, 0, local.Location())
+		if !next.After(local) {
+			next = next.AddDate(1, 0, 0)
+		}
+		return next
+	}
+
+	next := time.Date(local.Year(), local.Month(), 1, u.SummaryHour, 0, 0, 0, local.Location())
+	if !next.After(local) {
+		next = next.AddDate(0, 1, 0)
+	}
+	return next
+}
+
+// SendReport sends the user their report for the period that has just ended.
+// Unless force is set, nothing is sent if the user has paused notifications or
+// turned reports off.
+func (u *User) SendReport(notifier *Notifier, db *bbolt.DB, period ReportPeriod, baseUrl string, force bool) {
+	if !force && (u.Paused || u.NoReports) {
+		return
+	}
+
+	now := notifier.Clock.Now()
+	r := u.Report(period, u.periodStart(period, now).AddDate(0, 0, -1), now)
+	log.Debugf("producing %s report for %q", r.Title(), u.Username)
+
+	if err := notifier.Notify(u, Notification{Kind: "report", Body: u.ReportMessage(r, baseUrl)}); err != nil {
+		log.Errorf("failed sending %s report: %v", period, err)
+		return
+	}
+
+	updated, err := UpdateUser(db, u.Username, func(stored *User) error {
+		if period == ReportYear {
+			stored.LastYearlyReport = now
+		} else {
+			stored.LastMonthlyReport = now
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("failed to update last %s report date: %v", period, err)
+		return
+	}
+	u.LastYearlyReport, u.LastMonthlyReport = updated.LastYearlyReport, updated.LastMonthlyReport
+}
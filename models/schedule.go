@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+)
+
+type SummaryCadence string
+
+// The zero cadence is weekly, which was the only option before cadences were
+// configurable.
+const (
+	SummaryWeekly  SummaryCadence = ""
+	SummaryDaily   SummaryCadence = "daily"
+	SummaryMonthly SummaryCadence = "monthly"
+	SummaryOff     SummaryCadence = "off"
+)
+
+// SummaryCadences lists the valid cadences in the order they should be
+// offered to users.
+var SummaryCadences = []SummaryCadence{SummaryDaily, SummaryWeekly, SummaryMonthly, SummaryOff}
+
+func (c SummaryCadence) String() string {
+	if c == SummaryWeekly {
+		return "weekly"
+	}
+	return string(c)
+}
+
+// Days is the length of the period a summary with this cadence covers.
+func (c SummaryCadence) Days() int {
+	switch c {
+	case SummaryDaily:
+		return 1
+	case SummaryMonthly:
+		return 30
+	default:
+		return 7
+	}
+}
+
+// summaryCatchUp is how far back a missed summary is still sent, e.g. after
+// vator was down when it was due. Anything older is skipped.
+const summaryCatchUp = 25 * time.Hour
+
+// NextSummary returns when the user's next summary is due, which may be
+// before now if it is overdue, or the zero time if summaries are off. Daily
+// summaries go out at SummaryHour, weekly ones at SummaryHour on SummaryDay,
+// and monthly ones at SummaryHour on the first of the month, all local time.
+func (u *User) NextSummary(now time.Time) time.Time {
+	if u.SummaryCadence == SummaryOff {
+		return time.Time{}
+	}
+
+	base := u.LastSummary
+	if floor := now.Add(-summaryCatchUp); base.Before(floor) {
+		base = floor
+	}
+	local := base.In(u.Timezone())
+
+	switch u.SummaryCadence {
+	case SummaryMonthly:
+		next := time.Date(local.Year(), local.Month(), 1, u.SummaryHour, 0, 0, 0, local.Location())
+		if !next.After(local) {
+			next = next.AddDate(0, 1, 0)
+		}
+		return next
+	case SummaryDaily:
+		next := time.Date(local.Year(), local.Month(), local.Day(), u.SummaryHour, 0, 0, 0, local.Location())
+		if !next.After(local) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	default:
+		next := time.Date(local.Year(), local.Month(), local.Day(), u.SummaryHour, 0, 0, 0, local.Location())
+		for next.Weekday() != u.SummaryDay || !next.After(local) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	}
+}
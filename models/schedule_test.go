@@ -0,0 +1,221 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading time zone %q: %v", name, err)
+	}
+	return loc
+}
+
+func TestNextSummary(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	tokyo := mustLoad(t, "Asia/Tokyo")
+
+	tests := []struct {
+		name string
+		user *User
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "weekly defaults to midnight sunday in the default zone",
+			user: &User{},
+			now:  time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 10, 18, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekly on sunday at the user's hour in their zone",
+			user: &User{TimezoneName: "America/New_York", SummaryHour: 9},
+			now:  time.Date(2026, 10, 17, 12, 0, 0, 0, ny),
+			want: time.Date(2026, 10, 18, 9, 0, 0, 0, ny),
+		},
+		{
+			name: "it's already sunday in tokyo",
+			user: &User{TimezoneName: "Asia/Tokyo", SummaryHour: 8},
+			now:  time.Date(2026, 10, 17, 20, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 10, 18, 8, 0, 0, 0, tokyo),
+		},
+		{
+			name: "weekly on a chosen day",
+			user: &User{TimezoneName: "America/New_York", SummaryDay: time.Wednesday, SummaryHour: 18},
+			now:  time.Date(2026, 10, 17, 12, 0, 0, 0, ny),
+			want: time.Date(2026, 10, 21, 18, 0, 0, 0, ny),
+		},
+		{
+			name: "an overdue summary is still due within the catch-up window",
+			user: &User{TimezoneName: "America/New_York", SummaryHour: 9},
+			now:  time.Date(2026, 10, 18, 20, 0, 0, 0, ny),
+			want: time.Date(2026, 10, 18, 9, 0, 0, 0, ny),
+		},
+		{
+			name: "a summary overdue by more than 25 hours is skipped",
+			user: &User{TimezoneName: "America/New_York", SummaryHour: 9},
+			now:  time.Date(2026, 10, 19, 10, 30, 0, 0, ny),
+			want: time.Date(2026, 10, 25, 9, 0, 0, 0, ny),
+		},
+		{
+			name: "no second summary the same week",
+			user: &User{
+				TimezoneName: "America/New_York",
+				SummaryHour:  9,
+				LastSummary:  time.Date(2026, 10, 18, 9, 0, 5, 0, ny),
+			},
+			now:  time.Date(2026, 10, 18, 20, 0, 0, 0, ny),
+			want: time.Date(2026, 10, 25, 9, 0, 0, 0, ny),
+		},
+		{
+			name: "the local hour holds across the end of daylight saving time",
+			user: &User{
+				TimezoneName: "America/New_York",
+				SummaryHour:  9,
+				LastSummary:  time.Date(2026, 10, 25, 9, 0, 0, 0, ny),
+			},
+			now:  time.Date(2026, 10, 26, 12, 0, 0, 0, ny),
+			want: time.Date(2026, 11, 1, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "daily",
+			user: &User{
+				TimezoneName:   "America/New_York",
+				SummaryCadence: SummaryDaily,
+				SummaryHour:    7,
+				LastSummary:    time.Date(2026, 10, 17, 7, 0, 0, 0, ny),
+			},
+			now:  time.Date(2026, 10, 17, 12, 0, 0, 0, ny),
+			want: time.Date(2026, 10, 18, 7, 0, 0, 0, ny),
+		},
+		{
+			name: "monthly",
+			user: &User{TimezoneName: "America/New_York", SummaryCadence: SummaryMonthly, SummaryHour: 7},
+			now:  time.Date(2026, 10, 17, 12, 0, 0, 0, ny),
+			want: time.Date(2026, 11, 1, 7, 0, 0, 0, ny),
+		},
+		{
+			name: "off",
+			user: &User{SummaryCadence: SummaryOff},
+			now:  time.Date(2026, 10, 17, 12, 0, 0, 0, ny),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.user.NextSummary(test.now)
+			if !got.Equal(test.want) {
+				t.Errorf("NextSummary(%s) = %s, want %s", test.now, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNextReport(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, ny)
+
+	tests := []struct {
+		name   string
+		user   *User
+		period ReportPeriod
+		want   time.Time
+	}{
+		{
+			name:   "monthly on the first at the user's hour",
+			user:   &User{TimezoneName: "America/New_York", SummaryHour: 7},
+			period: ReportMonth,
+			want:   time.Date(2026, 11, 1, 7, 0, 0, 0, ny),
+		},
+		{
+			name:   "yearly on new year's day",
+			user:   &User{TimezoneName: "America/New_York", SummaryHour: 7},
+			period: ReportYear,
+			want:   time.Date(2027, 1, 1, 7, 0, 0, 0, ny),
+		},
+		{
+			name:   "still sent with summaries off",
+			user:   &User{TimezoneName: "America/New_York", SummaryCadence: SummaryOff, SummaryHour: 7},
+			period: ReportMonth,
+			want:   time.Date(2026, 11, 1, 7, 0, 0, 0, ny),
+		},
+		{
+			name:   "off",
+			user:   &User{TimezoneName: "America/New_York", NoReports: true},
+			period: ReportMonth,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.user.NextReport(test.period, now)
+			if !got.Equal(test.want) {
+				t.Errorf("NextReport(%s, %s) = %s, want %s", test.period, now, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	clock := NewFakeClock(time.Date(2026, 10, 18, 9, 0, 0, 0, ny))
+
+	db := testDb(t)
+	notifier := NewNotifier(db, nil, nil)
+	notifier.DryRun = true
+	notifier.Clock = clock
+
+	summaries := func(username string) []*OutboundMessage {
+		msgs, err := ListOutbox(db, func(m *OutboundMessage) bool {
+			return m.Username == username && m.Kind == "summary"
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msgs
+	}
+
+	t.Run("sent and recorded", func(t *testing.T) {
+		u := &User{Username: "weekly", TimezoneName: "America/New_York"}
+		if err := u.Save(db); err != nil {
+			t.Fatal(err)
+		}
+		u.Summary(notifier, db, false)
+		if n := len(summaries("weekly")); n != 1 {
+			t.Fatalf("got %d summaries, want 1", n)
+		}
+		if !u.LastSummary.Equal(clock.Now()) {
+			t.Errorf("LastSummary = %s, want %s", u.LastSummary, clock.Now())
+		}
+		saved, err := LoadUser(db, "weekly")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !saved.LastSummary.Equal(clock.Now()) {
+			t.Errorf("saved LastSummary = %s, want %s", saved.LastSummary, clock.Now())
+		}
+	})
+
+	for _, u := range []*User{
+		{Username: "paused", Paused: true},
+		{Username: "off", SummaryCadence: SummaryOff},
+	} {
+		t.Run(u.Username, func(t *testing.T) {
+			if err := u.Save(db); err != nil {
+				t.Fatal(err)
+			}
+			u.Summary(notifier, db, false)
+			if n := len(summaries(u.Username)); n != 0 {
+				t.Errorf("got %d summaries, want none", n)
+			}
+
+			u.Summary(notifier, db, true)
+			if n := len(summaries(u.Username)); n != 1 {
+				t.Errorf("forced: got %d summaries, want 1", n)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/metrics"
 	"go.etcd.io/bbolt"
 )
 
@@ -44,7 +45,7 @@ func WithNewSession(db *bbolt.DB, handler func(http.ResponseWriter, *http.Reques
 }
 
 func SessionCopy(db *bbolt.DB, old, new string) error {
-	err := db.Update(func(tx *bbolt.Tx) error {
+	err := metrics.Update(db, "session.copy", func(tx *bbolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte("sessions"))
 		if err != nil {
 			return fmt.Errorf("creating sessions bucket: %s", err)
@@ -95,7 +96,7 @@ func WithSession(db *bbolt.DB, handler func(http.ResponseWriter, *http.Request))
 }
 
 func SessionExists(db *bbolt.DB, sid string) bool {
-	err := db.View(func(tx *bbolt.Tx) error {
+	err := metrics.View(db, "session.exists", func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("sessions"))
 		if b == nil {
 			return errors.New("no bucket")
@@ -130,7 +131,7 @@ func SessionGetMulti(db *bbolt.DB, req *http.Request, keys []string) (values []s
 	if sid == "" {
 		return values, errors.New("no session ID")
 	}
-	err = db.View(func(tx *bbolt.Tx) error {
+	err = metrics.View(db, "session.get", func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("sessions"))
 		if b == nil {
 			return errors.New("no bucket")
@@ -168,7 +169,7 @@ func SessionDeleteReq(db *bbolt.DB, req *http.Request) error {
 }
 
 func SessionDelete(db *bbolt.DB, sid string) error {
-	err := db.Update(func(tx *bbolt.Tx) error {
+	err := metrics.Update(db, "session.delete", func(tx *bbolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte("sessions"))
 		if err != nil {
 			return fmt.Errorf("creating sessions bucket: %s", err)
@@ -194,7 +195,7 @@ func SessionSetMulti(db *bbolt.DB, req *http.Request, keys []string, values []st
 	if sid == "" {
 		return errors.New("no session ID")
 	}
-	err := db.Update(func(tx *bbolt.Tx) error {
+	err := metrics.Update(db, "session.set", func(tx *bbolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte("sessions"))
 		if err != nil {
 			return fmt.Errorf("creating sessions bucket: %s", err)
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const TelegramDefaultApi = "https://api.telegram.org"
+
+// Telegram is a minimal client for the Telegram Bot API. BaseUrl is the API
+// root (normally TelegramDefaultApi), which can be pointed at a stub for
+// testing.
+type Telegram struct {
+	Token    string
+	BaseUrl  string
+	Username string
+}
+
+type TelegramUpdate struct {
+	UpdateId int64            `json:"update_id"`
+	Message  *TelegramMessage `json:"message"`
+}
+
+type TelegramMessage struct {
+	MessageId int64        `json:"message_id"`
+	Chat      TelegramChat `json:"chat"`
+	Text      string       `json:"text"`
+}
+
+type TelegramChat struct {
+	Id int64 `json:"id"`
+}
+
+type telegramResponse struct {
+	Ok          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+func (t *Telegram) call(ctx context.Context, method string, params url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/bot%s/%s", strings.TrimSuffix(t.BaseUrl, "/"), t.Token, method),
+		strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("building %s request: %s", method, err)
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending %s request: %s", method, err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("reading %s response body: %s", method, err)
+	}
+
+	var resObj telegramResponse
+	if err := json.Unmarshal(body, &resObj); err != nil {
+		return fmt.Errorf("parsing %s response %q: %s", method, string(body), err)
+	}
+	if !resObj.Ok || res.StatusCode/100 != 2 {
+		return fmt.Errorf("non-OK %d response for %s: %s", res.StatusCode, method, resObj.Description)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resObj.Result, result); err != nil {
+		return fmt.Errorf("parsing %s result %q: %s", method, string(resObj.Result), err)
+	}
+	return nil
+}
+
+// SendMessage sends msg to the given chat and returns the ID Telegram assigned
+// the message.
+func (t *Telegram) SendMessage(chatId int64, msg string) (string, error) {
+	data := url.Values{}
+	data.Set("chat_id", strconv.FormatInt(chatId, 10))
+	data.Set("text", msg)
+
+	var sent TelegramMessage
+	if err := t.call(context.Background(), "sendMessage", data, &sent); err != nil {
+		return "", fmt.Errorf("sending to chat %d: %s", chatId, err)
+	}
+	return strconv.FormatInt(sent.MessageId, 10), nil
+}
+
+// GetUpdates long-polls for new messages sent to the bot, until timeout passes
+// or ctx is done. Only updates with an ID of at least offset are returned;
+// callers should pass one more than the last update ID they processed.
+func (t *Telegram) GetUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]TelegramUpdate, error) {
+	data := url.Values{}
+	data.Set("offset", strconv.FormatInt(offset, 10))
+	data.Set("timeout", strconv.Itoa(int(timeout.Seconds())))
+	data.Set("allowed_updates", `["message"]`)
+
+	var updates []TelegramUpdate
+	if err := t.call(ctx, "getUpdates", data, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// Ping checks that the bot API can be reached with the bot's token.
+func (t *Telegram) Ping(ctx context.Context) error {
+	return t.call(ctx, "getMe", nil, nil)
+}
+
+func NewTelegram(token, baseUrl string) (*Telegram, error) {
+	if baseUrl == "" {
+		baseUrl = TelegramDefaultApi
+	}
+	ret := &Telegram{Token: token, BaseUrl: baseUrl}
+
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err := ret.call(context.Background(), "getMe", nil, &me); err != nil {
+		return nil, err
+	}
+	if me.Username == "" {
+		return nil, errors.New("no error, but telegram bot username was blank")
+	}
+	ret.Username = me.Username
+
+	return ret, nil
+}
+
+const telegramCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewTelegramCode generates a random one-time code that a user sends to the
+// bot to link their Telegram chat to their vator account.
+func NewTelegramCode() (string, error) {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(telegramCodeAlphabet))))
+		if err != nil {
+			return "", fmt.Errorf("generating telegram code: %s", err)
+		}
+		code[i] = telegramCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
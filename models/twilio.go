@@ -1,6 +1,10 @@
 package models
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,16 +13,37 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 )
 
 var log = Log
 
+const TwilioDefaultApi = "https://api.twilio.com"
+
 type Twilio struct {
 	Sid             string
 	AuthToken       string `json:"auth_token"`
 	Status          string
 	SubResourceUris map[string]string `json:"subresource_uris"`
+
+	TwilioOptions `json:"-"`
+
+	fromMu sync.Mutex
+	from   string
+}
+
+// TwilioOptions controls how messages are sent. If MessagingServiceSid is set,
+// Twilio picks the sender from that service's pool. Otherwise messages come
+// from From, or if that is blank, the account's first incoming number.
+type TwilioOptions struct {
+	BaseUrl             string
+	From                string
+	MessagingServiceSid string
+
+	// StatusCallback, if set, is the URL Twilio reports delivery status to.
+	StatusCallback string
 }
 
 type IncomingPhoneNumbersResponse struct {
@@ -30,45 +55,109 @@ type PhoneNumber struct {
 	PhoneNumber string `json:"phone_number"`
 }
 
-func (t *Twilio) SendSms(to, msg string) error {
+func (t *Twilio) url(path string) string {
+	return strings.TrimSuffix(t.BaseUrl, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// sender returns the number messages should be sent from, looking it up only
+// the first time if none was configured.
+func (t *Twilio) sender() (string, error) {
+	if t.From != "" {
+		return t.From, nil
+	}
+
+	t.fromMu.Lock()
+	defer t.fromMu.Unlock()
+	if t.from != "" {
+		return t.from, nil
+	}
+
 	from, err := t.PhoneNumber()
 	if err != nil {
-		return fmt.Errorf("sending to %q: %s", to, err)
+		return "", err
 	}
 	if from == "" {
-		return errors.New("no error, but twilio phone number was blank")
+		return "", errors.New("no error, but twilio phone number was blank")
 	}
+	t.from = from
+	return from, nil
+}
+
+// SendSms sends msg to the given number and returns the SID Twilio assigned
+// the message.
+func (t *Twilio) SendSms(to, msg string) (string, error) {
 	data := url.Values{}
 	data.Set("To", to)
-	data.Set("From", from)
 	data.Set("Body", msg)
+	if t.MessagingServiceSid != "" {
+		data.Set("MessagingServiceSid", t.MessagingServiceSid)
+	} else {
+		from, err := t.sender()
+		if err != nil {
+			return "", fmt.Errorf("sending to %q: %s", to, err)
+		}
+		data.Set("From", from)
+	}
+	if t.StatusCallback != "" {
+		data.Set("StatusCallback", t.StatusCallback)
+	}
 	rdr := strings.NewReader(data.Encode())
 	req, err := http.NewRequest(
 		"POST",
-		fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", t.Sid),
+		t.url(fmt.Sprintf("/2010-04-01/Accounts/%s/Messages.json", t.Sid)),
 		rdr)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-type", "application/x-www-form-urlencoded")
-
 	if err != nil {
-		return fmt.Errorf("building request to send to %q: %s", to, err)
+		return "", fmt.Errorf("building request to send to %q: %s", to, err)
 	}
 
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-type", "application/x-www-form-urlencoded")
 	req.SetBasicAuth(t.Sid, t.AuthToken)
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("sending request to send to %q: %s", to, err)
+		return "", fmt.Errorf("sending request to send to %q: %s", to, err)
 	}
 	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Warningf("failed reading response body: %s", err)
+	}
 	if res.StatusCode/100 != 2 {
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			log.Warning("failed reading request body: %s", err)
+		return "", fmt.Errorf("non-2XX %d sending request to send to %q: %q", res.StatusCode, to, string(body))
+	}
+
+	var resObj struct {
+		Sid string
+	}
+	if err := json.Unmarshal(body, &resObj); err != nil {
+		log.Warningf("parsing response body %q for message to %q: %s", string(body), to, err)
+	}
+	return resObj.Sid, nil
+}
+
+// ValidSignature reports whether signature, the value of a webhook request's
+// X-Twilio-Signature header, matches the HMAC Twilio would compute for a POST
+// to fullUrl with the given form parameters.
+func (t *Twilio) ValidSignature(fullUrl string, params url.Values, signature string) bool {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	payload := fullUrl
+	for _, k := range keys {
+		for _, v := range params[k] {
+			payload += k + v
 		}
-		return fmt.Errorf("non-2XX %d sending request to send to %q: %q", res.StatusCode, to, string(body))
 	}
-	return nil
+
+	mac := hmac.New(sha1.New, []byte(t.AuthToken))
+	mac.Write([]byte(payload))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
 }
 
 func (t *Twilio) PhoneNumber() (string, error) {
@@ -76,7 +165,7 @@ func (t *Twilio) PhoneNumber() (string, error) {
 	if !ok {
 		return "", errors.New("subresources URIs did not contain incoming_phone_numbers")
 	}
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.twilio.com/%s", uri), nil)
+	req, err := http.NewRequest("GET", t.url(uri), nil)
 	if err != nil {
 		return "", fmt.Errorf("building API request for incoming_phone_numbers: %s", err)
 	}
@@ -108,8 +197,46 @@ func (t *Twilio) PhoneNumber() (string, error) {
 	return resObj.IncomingPhoneNumbers[0].PhoneNumber, nil
 }
 
-func NewTwilio(twSid, twToken string) (*Twilio, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s.json", twSid), nil)
+// Ping checks that twilio can be reached with the account's credentials, and
+// that the account is still active.
+func (t *Twilio) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", t.url(fmt.Sprintf("/2010-04-01/Accounts/%s.json", t.Sid)), nil)
+	if err != nil {
+		return fmt.Errorf("building API request: %s", err)
+	}
+	req.SetBasicAuth(t.Sid, t.AuthToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending API request: %s", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("error reading response body: %s", err)
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("non-2XX %d sending API request: %s", res.StatusCode, string(body))
+	}
+
+	var account struct {
+		Status string
+	}
+	if err := json.Unmarshal(body, &account); err != nil {
+		return fmt.Errorf("parsing json %q: %s", string(body), err)
+	}
+	if account.Status != "active" {
+		return fmt.Errorf("twilio account status is %q, not active", account.Status)
+	}
+	return nil
+}
+
+func NewTwilio(twSid, twToken string, opts TwilioOptions) (*Twilio, error) {
+	if opts.BaseUrl == "" {
+		opts.BaseUrl = TwilioDefaultApi
+	}
+	ret := &Twilio{TwilioOptions: opts}
+
+	req, err := http.NewRequest("GET", ret.url(fmt.Sprintf("/2010-04-01/Accounts/%s.json", twSid)), nil)
 	if err != nil {
 		return nil, fmt.Errorf("building API request: %s", err)
 	}
@@ -126,7 +253,6 @@ func NewTwilio(twSid, twToken string) (*Twilio, error) {
 	if res.StatusCode/100 != 2 {
 		return nil, fmt.Errorf("non-2XX %d sending API request: %s", res.StatusCode, string(body))
 	}
-	ret := &Twilio{}
 	if err := json.Unmarshal(body, ret); err != nil {
 		return nil, fmt.Errorf("parsing json %q: %s", string(body), err)
 	}
@@ -135,8 +261,10 @@ func NewTwilio(twSid, twToken string) (*Twilio, error) {
 		return nil, fmt.Errorf("twilio account status is %q, not active", ret.Status)
 	}
 
-	if _, err := ret.PhoneNumber(); err != nil {
-		return nil, fmt.Errorf("twilio account has no phone numbers: %s", err)
+	if ret.MessagingServiceSid == "" {
+		if _, err := ret.sender(); err != nil {
+			return nil, fmt.Errorf("twilio account has no phone numbers: %s", err)
+		}
 	}
 
 	return ret, nil
@@ -11,13 +11,11 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/metrics"
 	"github.com/asymmetricia/withings"
-	"github.com/cbroglie/mustache"
-	errors2 "github.com/pkg/errors"
 	"go.etcd.io/bbolt"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -29,14 +27,21 @@ func init() {
 }
 
 type User struct {
-	Mu sync.RWMutex
-
 	Username       string
 	HashedPassword []byte
 	LastWeight     time.Time
 	BackFillDate   time.Time
 	Weights        []Weight
 	Phone          string
+	PhoneVerified  bool
+	SmsConsent     SmsConsent
+	ConsentLog     []ConsentChange
+
+	PendingPhone      string
+	PhoneCode         string
+	PhoneCodeExpiry   time.Time
+	PhoneCodeAttempts int
+	PhoneCodesSent    []time.Time
 
 	AccessToken   string
 	RefreshSecret string
@@ -46,6 +51,51 @@ type User struct {
 	LastSummary  time.Time
 	TimezoneName string
 	Share        bool
+	Paused       bool
+
+	// DryRun renders and stores the user's messages without sending them.
+	DryRun bool
+
+	// QuietStart and QuietEnd are local hours between which messages are
+	// held; DailyCap limits how many are sent per channel each day.
+	QuietStart int
+	QuietEnd   int
+	DailyCap   int
+
+	SummaryCadence SummaryCadence
+	SummaryDay     time.Weekday
+	SummaryHour    int
+
+	// NoReports turns off the monthly and yearly reports, which are sent at
+	// SummaryHour whatever SummaryCadence is.
+	NoReports         bool
+	LastMonthlyReport time.Time
+	LastYearlyReport  time.Time
+
+	// ReminderDays is how long without a weigh-in before the user is
+	// reminded; zero turns reminders off.
+	ReminderDays  int
+	RemindersSent int
+	LastReminder  time.Time
+
+	// MilestoneStartKgs is the weight loss milestones are measured from, and
+	// HeightCm is used for BMI; either may be zero if unset.
+	MilestoneStartKgs float64
+	HeightCm          float64
+	Milestones        []Milestone
+	LowestTrend       float64
+	LowestTrendDate   time.Time
+	LastLowMilestone  time.Time
+	BmiCategory       string
+
+	TelegramChatId int64
+	TelegramCode   string
+
+	// Language and Tone choose which of the catalog's templates the user's
+	// messages are rendered from.
+	Language        string
+	Tone            string
+	RecentTemplates []string
 }
 
 type Weight struct {
@@ -55,12 +105,12 @@ type Weight struct {
 
 var UserNotFound = errors.New("user not found")
 
-func (u *User) WithingsUser(db *bbolt.DB, client *withings.Client) (*withings.User, error) {
+func (u *User) WithingsUser(db *bbolt.DB, client *Withings) (*withings.User, error) {
 	if u.RefreshSecret == "" {
 		return nil, errors.New("not linked")
 	}
 
-	wtu, err := client.NewUserFromAccessToken(context.Background(), u.AccessToken, u.TokenExpiry, u.RefreshSecret)
+	wtu, err := client.UserFromTokens(context.Background(), u.AccessToken, u.TokenExpiry, u.RefreshSecret)
 	if err != nil {
 		return nil, fmt.Errorf("could not obtain access token in WithingsUser: %w", err)
 	}
@@ -78,24 +128,11 @@ func LoadUserRequest(db *bbolt.DB, req *http.Request) (*User, error) {
 }
 
 func LoadUser(db *bbolt.DB, username string) (*User, error) {
-	username = strings.ToLower(username)
-
 	var user *User
-	err := db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("users"))
-		if b == nil {
-			return fmt.Errorf("user %q: %w", username, UserNotFound)
-		}
-		u := b.Get([]byte(username))
-		if u == nil {
-			return fmt.Errorf("user %q: %w", username, UserNotFound)
-		}
-		user = &User{}
-		if err := json.Unmarshal(u, user); err != nil {
-			Log.Errorf("user record for %q (%q) corrupt: %s", username, string(u), err)
-			return fmt.Errorf("user %q: %w", username, UserNotFound)
-		}
-		return nil
+	err := metrics.View(db, "user.load", func(tx *bbolt.Tx) error {
+		var err error
+		user, err = loadUser(tx, username)
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -103,42 +140,97 @@ func LoadUser(db *bbolt.DB, username string) (*User, error) {
 	return user, nil
 }
 
+// loadUser reads the named user's record within tx.
+func loadUser(tx *bbolt.Tx, username string) (*User, error) {
+	username = strings.ToLower(username)
+
+	b := tx.Bucket([]byte("users"))
+	if b == nil {
+		return nil, fmt.Errorf("user %q: %w", username, UserNotFound)
+	}
+	u := b.Get([]byte(username))
+	if u == nil {
+		return nil, fmt.Errorf("user %q: %w", username, UserNotFound)
+	}
+	user := &User{}
+	if err := json.Unmarshal(u, user); err != nil {
+		Log.Errorf("user record for %q (%q) corrupt: %s", username, string(u), err)
+		return nil, fmt.Errorf("user %q: %w", username, UserNotFound)
+	}
+	return user, nil
+}
+
+// Save writes the whole user record, replacing whatever was stored. It suits
+// new users and tools that have the database to themselves; elsewhere, use
+// UpdateUser so as not to overwrite changes made since the user was loaded.
 func (u *User) Save(db *bbolt.DB) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte("users"))
-		if err != nil {
-			return fmt.Errorf("opening users bucket: %s", err)
-		}
-		u.Username = strings.ToLower(u.Username)
-		user, err := json.Marshal(u)
-		if err != nil {
-			return fmt.Errorf("marshalling user into JSON: %s", err)
+	return metrics.Update(db, "user.save", u.put)
+}
+
+// put writes the user's record within tx.
+func (u *User) put(tx *bbolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("users"))
+	if err != nil {
+		return fmt.Errorf("opening users bucket: %s", err)
+	}
+	u.Username = strings.ToLower(u.Username)
+	user, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("marshalling user into JSON: %s", err)
+	}
+	if err := b.Put([]byte(u.Username), user); err != nil {
+		return err
+	}
+	Log.Debugf("saved user %q w/ %d weights", u.Username, len(u.Weights))
+	return nil
+}
+
+// UpdateUser loads the named user, applies update, and saves the result, all
+// in one transaction, so that concurrent updates to a user are applied one
+// after the other rather than overwriting each other. Nothing is saved if
+// update returns an error. Other writers wait while update runs, so it should
+// be quick, and it must not use db itself; send messages, call APIs and so on
+// before or after. The updated user is returned.
+func UpdateUser(db *bbolt.DB, username string, update func(u *User) error) (*User, error) {
+	var user *User
+	err := metrics.Update(db, "user.update", func(tx *bbolt.Tx) error {
+		var err error
+		if user, err = loadUser(tx, username); err != nil {
+			return err
 		}
-		if err := b.Put([]byte(u.Username), user); err != nil {
+		if err := update(user); err != nil {
 			return err
 		}
-		Log.Debugf("saved user %q w/ %d weights", u.Username, len(u.Weights))
-		return nil
+		return user.put(tx)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
+// Rename moves the user's record to newName, along with their outbox. Only the
+// name is taken from u; the rest of the record is as stored.
 func (u *User) Rename(db *bbolt.DB, newName string) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte("users"))
+	return metrics.Update(db, "user.rename", func(tx *bbolt.Tx) error {
+		deadName := strings.ToLower(u.Username)
+		user, err := loadUser(tx, deadName)
 		if err != nil {
-			return fmt.Errorf("opening users bucket: %s", err)
+			return err
 		}
 
-		deadName := strings.ToLower(u.Username)
-		u.Username = strings.ToLower(newName)
-		user, err := json.Marshal(u)
+		user.Username = strings.ToLower(newName)
+		err = user.put(tx)
 		if err == nil {
-			err = b.Put([]byte(u.Username), user)
+			err = tx.Bucket([]byte("users")).Delete([]byte(deadName))
 		}
 		if err == nil {
-			err = b.Delete([]byte(deadName))
+			err = renameOutbox(tx, deadName, user.Username)
+		}
+		if err != nil {
+			return err
 		}
-		Log.Debugf("saved user %q w/ %d weights", u.Username, len(u.Weights))
+		u.Username = user.Username
 		return nil
 	})
 }
@@ -153,7 +245,7 @@ func (u *User) SetPassword(newPassword string) error {
 }
 
 func TidyUsers(db *bbolt.DB) {
-	err := db.Update(func(tx *bbolt.Tx) error {
+	err := metrics.Update(db, "user.tidy", func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("users"))
 		if b == nil {
 			return nil
@@ -179,6 +271,20 @@ func TidyUsers(db *bbolt.DB) {
 			}
 
 			// do some tidying here if need be
+			if user.Phone != "" && !user.PhoneVerified {
+				normalized, err := NormalizePhone(user.Phone)
+				if err != nil || normalized == user.Phone {
+					continue
+				}
+				log.Infof("normalizing unverified phone for %q", username)
+				user.Phone = normalized
+				if userJson, err = json.Marshal(&user); err == nil {
+					err = b.Put([]byte(username), userJson)
+				}
+				if err != nil {
+					log.Warningf("saving normalized phone for %q: %v", username, err)
+				}
+			}
 		}
 
 		return nil
@@ -189,9 +295,68 @@ func TidyUsers(db *bbolt.DB) {
 	}
 }
 
+// FindUser returns the first user, linked or not, for which match returns true.
+// If no user matches, the returned error wraps UserNotFound.
+func FindUser(db *bbolt.DB, match func(u *User) bool) (*User, error) {
+	var found *User
+	err := metrics.View(db, "user.find", func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if found != nil {
+				return nil
+			}
+			u := &User{}
+			if err := json.Unmarshal(v, u); err != nil {
+				Log.Warningf("skipping malformed user %s: %q", string(k), string(v))
+				return nil
+			}
+			if match(u) {
+				found = u
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("searching users bucket: %w", err)
+	}
+	if found == nil {
+		return nil, UserNotFound
+	}
+	return found, nil
+}
+
+// FindUsers returns every user, linked or not, for which match returns true.
+func FindUsers(db *bbolt.DB, match func(u *User) bool) ([]*User, error) {
+	var found []*User
+	err := metrics.View(db, "user.find_all", func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			u := &User{}
+			if err := json.Unmarshal(v, u); err != nil {
+				Log.Warningf("skipping malformed user %s: %q", string(k), string(v))
+				return nil
+			}
+			if match(u) {
+				found = append(found, u)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("searching users bucket: %w", err)
+	}
+	return found, nil
+}
+
 func GetUsers(db *bbolt.DB) []*User {
 	var users []*User
-	err := db.View(func(tx *bbolt.Tx) error {
+	err := metrics.View(db, "user.list", func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("users"))
 		if b == nil {
 			return nil
@@ -199,7 +364,7 @@ func GetUsers(db *bbolt.DB) []*User {
 		err := b.ForEach(func(k, v []byte) error {
 			u := &User{}
 			if err := json.Unmarshal(v, u); err != nil {
-				Log.Warning("skipping malformed user %s: %q", string(k), string(v))
+				Log.Warningf("skipping malformed user %s: %q", string(k), string(v))
 				return nil
 			}
 			if u.RefreshSecret == "" {
@@ -245,13 +410,22 @@ func (u *User) SaveOauthTokens(db *bbolt.DB, user *withings.User) {
 	}
 
 	log.Debugf("saving updated refresh secret for user %q", u.Username)
-	if err := u.Save(db); err != nil {
+	_, err := UpdateUser(db, u.Username, func(stored *User) error {
+		stored.RefreshSecret = u.RefreshSecret
+		stored.AccessToken = u.AccessToken
+		stored.TokenExpiry = u.TokenExpiry
+		return nil
+	})
+	if err != nil {
 		log.Errorf("saving user due to refresh token update: %v", err)
 	}
 }
 
-func (u *User) GetWeights(db *bbolt.DB, wtClient *withings.Client,
-	from time.Time, to time.Time) error {
+// GetWeights fetches the user's weigh-ins between from and to from withings
+// and adds any that are new, returning how many were. u is refreshed from the
+// saved record.
+func (u *User) GetWeights(db *bbolt.DB, wtClient *Withings,
+	from time.Time, to time.Time) (int, error) {
 
 	Log.Debugf("getting weights for %q from %s to %s", u.Username,
 		from, to)
@@ -261,30 +435,51 @@ func (u *User) GetWeights(db *bbolt.DB, wtClient *withings.Client,
 	var measuresResp withings.BodyMeasuresResp
 
 	if err == nil {
-		measuresResp, err = user.GetBodyMeasures(&withings.BodyMeasuresQueryParams{
+		ctx, cancel := context.WithTimeout(wtClient.Context(context.Background()), wtClient.Timeout)
+		measuresResp, err = user.GetBodyMeasuresCtx(ctx, &withings.BodyMeasuresQueryParams{
 			StartDate: &from,
 			EndDate:   &to})
+		cancel()
 		u.SaveOauthTokens(db, user)
 	}
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 	measures := measuresResp.ParseData()
 
 	Log.Debugf("%q: got %d weights", u.Username, len(measures.Weights))
 
 	if len(measures.Weights) == 0 {
-		return nil
+		return 0, nil
 	}
 
+	var weights []Weight
 	for _, weight := range measures.Weights {
-		u.Weights = append(u.Weights, Weight{
+		weights = append(weights, Weight{
 			Date: weight.Date,
 			Kgs:  weight.Kgs,
 		})
 	}
 
+	var added int
+	updated, err := UpdateUser(db, u.Username, func(stored *User) error {
+		added = stored.addWeights(weights)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	*u = *updated
+	return added, nil
+}
+
+// addWeights merges weights into the user's, skipping any already recorded,
+// and returns how many were added.
+func (u *User) addWeights(weights []Weight) int {
+	before := len(u.Weights)
+	u.Weights = append(u.Weights, weights...)
+
 	sort.Slice(u.Weights, func(i, j int) bool {
 		return u.Weights[i].Date.Before(u.Weights[j].Date)
 	})
@@ -304,17 +499,17 @@ func (u *User) GetWeights(db *bbolt.DB, wtClient *withings.Client,
 	Log.Debugf("%q: de-duplicated %d weights", u.Username, len(u.Weights)-len(deDuplicated))
 	u.Weights = deDuplicated
 
-	return u.Save(db)
+	return len(u.Weights) - before
 }
 
-// MovingAverageWeight calculates the moving average of the user's weight. `days` specifies the size of the window, and
-// `shift` specifies how many days in the past the window should be moved. An error will be returned if there are not
-// enough samples.
-func (u *User) MovingAverageWeight(days int, shift int) (float64, error) {
+// MovingAverageWeight calculates the moving average of the user's weight as of now. `days` specifies the size of the
+// window, and `shift` specifies how many days in the past the window should be moved. An error will be returned if
+// there are not enough samples.
+func (u *User) MovingAverageWeight(now time.Time, days int, shift int) (float64, error) {
 	var samples []float64
 	for cursor := 0; cursor < days; cursor++ {
 		var daySamples []float64
-		targetDay := time.Now().AddDate(0, 0, -shift-cursor).Truncate(24 * time.Hour)
+		targetDay := now.AddDate(0, 0, -shift-cursor).Truncate(24 * time.Hour)
 		for i := len(u.Weights) - 1; i >= 0; i-- {
 			weightDay := u.Weights[i].Date.Truncate(24 * time.Hour)
 			if !targetDay.Equal(weightDay) {
@@ -340,175 +535,273 @@ func (u *User) MovingAverageWeight(days int, shift int) (float64, error) {
 	return 0, errors.New("insufficient samples")
 }
 
-func (u *User) sendSms(twilio *Twilio, message string) error {
-	if u.Phone == "" {
-		return fmt.Errorf("user %q has no registered phone, cannot toast", u.Username)
-	}
-
-	if twilio == nil {
-		return errors.New("twilio mis- or un-configured, cannot toast")
-	}
-
-	if err := twilio.SendSms(u.Phone, message); err != nil {
-		return errors2.WithMessagef(err, "sending toast to %s at %s", u.Username, u.Phone)
-	}
-
-	return nil
-}
-
 var InsufficientData = errors.New("insufficient data")
 var Unwarranted = errors.New("unwarranted")
 
-func (u *User) toastN(days int, twilio *Twilio, encourage bool) error {
-	current, err := u.MovingAverageWeight(days, 0)
+func (u *User) toastN(now time.Time, days int, encourage bool) (Notification, error) {
+	current, err := u.MovingAverageWeight(now, days, 0)
 	if err != nil {
-		return InsufficientData
+		return Notification{}, InsufficientData
 	}
-	prev, err := u.MovingAverageWeight(days, 1)
+	prev, err := u.MovingAverageWeight(now, days, 1)
 	if err != nil {
-		return InsufficientData
+		return Notification{}, InsufficientData
 	}
 
 	Log.Infof("%d-day, previous: %.2f, now: %.2f", days, prev, current)
 
-	englishDay := strconv.Itoa(days)
-	switch days {
-	case 5:
-		englishDay = "five"
-	case 30:
-		englishDay = "thirty"
+	kind := "toast"
+	if prev <= current {
+		if !encourage {
+			return Notification{}, Unwarranted
+		}
+		log.Infof("producing %d-day encouragement for %s", days, u.Username)
+		kind = "encouragement"
+	} else {
+		log.Infof("producing %d-day toast for %s!", days, u.Username)
 	}
 
+	tmpl, msg, err := u.renderMessage(kind, u.trendContext(days, prev, current))
+	if err != nil {
+		log.Errorf("rendering %s for %q: %s", kind, u.Username, err)
+		return Notification{}, errors.New("template failed")
+	}
+	return Notification{Kind: kind, Template: tmpl, Body: msg}, nil
+}
+
+// trendContext is what toast and encouragement templates are rendered with:
+// how the days-day average moved from prev to current.
+func (u *User) trendContext(days int, prev, current float64) map[string]string {
 	ctx := map[string]string{
-		"days":      englishDay,
+		"days":      Messages.Days(u.Locale(), days),
 		"direction": "down",
-		"delta":     u.FormatKg(prev - current),
+		"delta":     u.FormatKg(math.Abs(prev - current)),
 		"final":     u.FormatKg(current),
 		"unit":      u.Unit(),
 	}
-
-	var tmpl string
-
 	if prev <= current {
-		if !encourage {
-			return Unwarranted
-		}
-
-		log.Infof("sending %d-day encouragement to %s", days, u.Username)
 		ctx["direction"] = "up"
-		ctx["delta"] = u.FormatKg(current - prev)
-		tmpl = encourageToasts[rand.Intn(len(encourageToasts))]
-	} else {
-		log.Infof("sending %d-day toast for %s!", days, u.Username)
-		tmpl = toasts[rand.Intn(len(toasts))]
 	}
+	return ctx
+}
 
-	msg, err := mustache.Render(tmpl, ctx)
-	if err != nil {
-		log.Errorf("rendering toast template %q: %s", tmpl, err)
-		return errors.New("template failed")
+func (u *User) Toast(notifier *Notifier) {
+	if u.Paused {
+		log.Debugf("notifications paused for %q, not toasting", u.Username)
+		return
 	}
-	if err := u.sendSms(twilio, msg); err != nil {
-		log.Errorf("failed sending toast: %s", err)
+
+	if len(u.Weights) == 0 {
+		log.Infof("no weights logged for %s, cannot toast", u.Username)
+		return
 	}
 
-	return nil
-}
+	if notifier == nil {
+		log.Errorf("no notifier configured, cannot toast %q", u.Username)
+		return
+	}
 
-func (u *User) Toast(twilio *Twilio) {
-	if len(u.Weights) == 0 {
-		log.Info("no weights logged for %s, cannot toast", u.Username)
+	// Record the milestones reached and the templates chosen, so neither is
+	// repeated, before sending them; u is left alone, since the caller may
+	// still be using it.
+	var notes []Notification
+	user, err := UpdateUser(notifier.Db, u.Username, func(stored *User) error {
+		notes = stored.ToastNotifications(notifier.Clock.Now())
+		return nil
+	})
+	if err != nil {
+		log.Errorf("failed to save %q before toasting: %v", u.Username, err)
 		return
 	}
 
+	for _, note := range notes {
+		if err := notifier.Notify(user, note); err != nil {
+			log.Errorf("failed sending %s: %v", note.Kind, err)
+		}
+	}
+}
+
+// ToastNotifications renders what the user should be sent in response to
+// their latest weigh-in, as of now: any milestones they've reached, then a
+// toast, encouragement, or a request for more data. The milestones and the
+// templates chosen are recorded on the user, but it is up to the caller to
+// save them.
+func (u *User) ToastNotifications(now time.Time) []Notification {
 	sort.Slice(u.Weights, func(i, j int) bool { return u.Weights[i].Date.Before(u.Weights[j].Date) })
 
-	fiveErr := u.toastN(5, twilio, false)
+	notes := u.celebrate(now)
+
+	five, fiveErr := u.toastN(now, 5, false)
 	if fiveErr == nil {
-		return
+		return append(notes, five)
 	}
 
-	thirtyErr := u.toastN(30, twilio, true)
+	thirty, thirtyErr := u.toastN(now, 30, true)
 	if thirtyErr == nil {
-		return
+		return append(notes, thirty)
 	}
 
 	if fiveErr == InsufficientData && thirtyErr == InsufficientData {
 		log.Debugf("encouraging %q to provide more data", u.Username)
-		// send not enough data message
-		msg := notEnoughData[rand.Intn(len(notEnoughData))]
-		if err := u.sendSms(twilio, msg); err != nil {
-			log.Errorf("failed sending toast: %v", err)
+		tmpl, msg, err := u.renderMessage("not-enough-data", nil)
+		if err != nil {
+			log.Errorf("rendering not-enough-data for %q: %s", u.Username, err)
+			return notes
 		}
-		return
+		return append(notes, Notification{Kind: "not-enough-data", Template: tmpl, Body: msg})
 	}
 	log.Debugf("confusing toast results for %q: 5=%q, 30=%q", u.Username, fiveErr, thirtyErr)
+	return notes
 }
 
-func (u *User) Summary(twilio *Twilio, db *bbolt.DB, force bool) {
-	userTz := u.Timezone()
-	// Weekly summaries only on Sunday
-	if !force && time.Now().In(userTz).Weekday() != time.Sunday {
-		return
-	}
-
-	// One summary per day
-	if !force && time.Now().Sub(u.LastSummary).Hours() < 25 {
+// Summary sends the user a summary covering their chosen cadence. When it is
+// sent is up to the caller, normally the scheduler according to NextSummary;
+// unless force is set, nothing is sent if the user has paused notifications or
+// turned summaries off.
+func (u *User) Summary(notifier *Notifier, db *bbolt.DB, force bool) {
+	if !force && (u.Paused || u.SummaryCadence == SummaryOff) {
 		return
 	}
 
+	now := notifier.Clock.Now()
 	log.Debugf(
-		"summary: today is %s and last summary was %.01f hours ago; producing summary for %q",
-		time.Now().In(userTz).Weekday().String(),
-		time.Now().Sub(u.LastSummary).Hours(),
+		"summary: last summary was %.01f hours ago; producing %s summary for %q",
+		now.Sub(u.LastSummary).Hours(),
+		u.SummaryCadence,
 		u.Username,
 	)
 
+	msg := u.SummaryMessage(now, u.SummaryCadence.Days())
+
+	if err := notifier.Notify(u, Notification{Kind: "summary", Body: msg}); err != nil {
+		log.Errorf("failed sending %s summary: %v", u.SummaryCadence, err)
+		return
+	}
+
+	updated, err := UpdateUser(db, u.Username, func(stored *User) error {
+		stored.LastSummary = now
+		return nil
+	})
+	if err != nil {
+		log.Errorf("failed to update LastSummary date: %v", err)
+		return
+	}
+	u.LastSummary = updated.LastSummary
+}
+
+// SummaryMessage renders a summary of the given number of days up to now: how
+// the user's moving averages have changed over that period, and how often they
+// weighed in.
+func (u *User) SummaryMessage(now time.Time, days int) string {
+	userTz := u.Timezone()
 	msg := fmt.Sprintf("Since %s:",
-		time.Now().In(userTz).AddDate(0, 0, -7).Format("Mon Jan 2 2006"))
+		now.In(userTz).AddDate(0, 0, -days).Format("Mon Jan 2 2006"))
 
 	for _, delta := range []int{5, 30} {
 		msg += fmt.Sprintf("\n%d-day Average: ", delta)
-		now, err := u.MovingAverageWeight(delta, 0)
+		current, err := u.MovingAverageWeight(now, delta, 0)
 		if err != nil {
 			log.Errorf("calculating current %d-day moving average for %q: %v", delta, u.Username, err)
 			msg += "insufficient data :("
 			continue
 		}
 
-		then, err := u.MovingAverageWeight(delta, 7)
+		then, err := u.MovingAverageWeight(now, delta, days)
 		if err != nil {
-			log.Errorf("calculating 7-day-shifted %d-day moving average for %q: %v", delta, u.Username, err)
+			log.Errorf("calculating %d-day-shifted %d-day moving average for %q: %v", days, delta, u.Username, err)
 			msg += "insufficient data :("
 			continue
 		}
 
-		if now <= then {
+		if current <= then {
 			msg += " down "
 		} else {
 			msg += " up "
 		}
 
-		msg += u.FormatKg(math.Abs(now-then)) + u.Unit()
+		msg += u.FormatKg(math.Abs(current-then)) + u.Unit()
 	}
 
 	weighs := 0
 	for _, w := range u.Weights {
-		if w.Date.After(time.Now().Add(-7 * 24 * time.Hour)) {
+		if w.Date.After(now.AddDate(0, 0, -days)) {
 			weighs++
 		}
 	}
 	msg += fmt.Sprintf("\n%d weigh-ins on record", weighs)
 
-	if err := u.sendSms(twilio, msg); err != nil {
-		log.Errorf("failed sending weekly summary: %v", err)
-		return
+	return msg
+}
+
+// StatusMessage renders the user's 5- and 30-day moving averages as of now.
+func (u *User) StatusMessage(now time.Time) string {
+	var lines []string
+	for _, days := range []int{5, 30} {
+		avg, err := u.MovingAverageWeight(now, days, 0)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%d-day average: insufficient data :(", days))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%d-day average: %s%s", days, u.FormatKg(avg), u.Unit()))
 	}
+	return strings.Join(lines, "\n")
+}
 
-	u.LastSummary = time.Now()
-	if err := u.Save(db); err != nil {
-		log.Errorf("failed to update LastSummary date: %v", err)
+// TodayMessage renders the weigh-ins the user has recorded on now's date, in
+// their time zone, followed by their moving averages.
+func (u *User) TodayMessage(now time.Time) string {
+	tz := u.Timezone()
+	y, m, d := now.In(tz).Date()
+
+	var today []string
+	for _, w := range u.Weights {
+		wy, wm, wd := w.Date.In(tz).Date()
+		if wy == y && wm == m && wd == d {
+			today = append(today, fmt.Sprintf("%s at %s", u.FormatKg(w.Kgs)+u.Unit(), w.Date.In(tz).Format("3:04pm")))
+		}
+	}
+
+	msg := "No weigh-ins yet today."
+	if len(today) > 0 {
+		msg = "Today: " + strings.Join(today, ", ")
+	}
+	return msg + "\n" + u.StatusMessage(now)
+}
+
+// ParseWeight interprets s as a weight in the user's preferred unit and returns
+// it in kilograms.
+func (u *User) ParseWeight(s string) (float64, error) {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "s")
+	s = strings.TrimSpace(strings.TrimSuffix(s, u.Unit()))
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if !u.Kgs {
+		value /= PoundsFromKg
+	}
+	if value < 20 || value > 400 {
+		return 0, fmt.Errorf("%s%s does not look like a real weight", u.FormatKg(value), u.Unit())
+	}
+	return value, nil
+}
+
+// LogWeight records a manually-entered weight. LastWeight is not advanced, so
+// the next scan still picks up every measurement Withings has since then.
+func (u *User) LogWeight(kgs float64, at time.Time) {
+	u.Weights = append(u.Weights, Weight{Date: at, Kgs: kgs})
+	sort.Slice(u.Weights, func(i, j int) bool { return u.Weights[i].Date.Before(u.Weights[j].Date) })
+}
+
+// SamePhone reports whether phone refers to the same number as the user's
+// registered phone.
+func (u *User) SamePhone(phone string) bool {
+	mine, err := NormalizePhone(u.Phone)
+	if err != nil {
+		return false
 	}
+	theirs, err := NormalizePhone(phone)
+	return err == nil && mine == theirs
 }
 
 func (u *User) FormatKg(kgs float64) string {
@@ -533,7 +826,7 @@ func (u *User) Timezone() *time.Location {
 
 	loc, err := time.LoadLocation(u.TimezoneName)
 	if err != nil {
-		log.Errorf("user %q has bad time zone %q: %v", u.TimezoneName, err)
+		log.Errorf("user %q has bad time zone %q: %v", u.Username, u.TimezoneName, err)
 		loc, err = time.LoadLocation("America/Los_Angeles")
 	}
 
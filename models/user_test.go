@@ -0,0 +1,223 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func testDb(t *testing.T) *bbolt.DB {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "vator.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// daily returns a user who weighed kgs[i] i days before now, skipping NaNs.
+func daily(now time.Time, kgs ...float64) *User {
+	u := &User{}
+	for i, kg := range kgs {
+		if !math.IsNaN(kg) {
+			u.Weights = append(u.Weights, Weight{Date: now.AddDate(0, 0, -i), Kgs: kg})
+		}
+	}
+	return u
+}
+
+func TestMovingAverageWeight(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC)
+	gap := math.NaN()
+
+	tests := []struct {
+		name    string
+		user    *User
+		days    int
+		shift   int
+		want    float64
+		wantErr bool
+	}{
+		{name: "no weights", user: &User{}, days: 5, wantErr: true},
+		{name: "every day", user: daily(now, 80, 81, 82, 83, 84), days: 5, want: 82},
+		{name: "three of five days is enough", user: daily(now, 80, gap, 82, gap, 84), days: 5, want: 82},
+		{name: "two of five days is not", user: daily(now, 80, gap, gap, gap, 84), days: 5, wantErr: true},
+		{name: "only the window counts", user: daily(now, 80, gap, gap, gap, gap, 90, 90, 90), days: 5, wantErr: true},
+		{name: "shifted back a day", user: daily(now, 70, 80, 81, 82, 83, 84), days: 5, shift: 1, want: 82},
+		{
+			name: "multiple weigh-ins in a day are averaged first",
+			user: &User{Weights: []Weight{
+				{Date: now, Kgs: 80},
+				{Date: now.Add(-time.Hour), Kgs: 82},
+				{Date: now.AddDate(0, 0, -1), Kgs: 84},
+				{Date: now.AddDate(0, 0, -2), Kgs: 84},
+			}},
+			days: 5,
+			want: (81 + 84 + 84) / 3.0,
+		},
+		{name: "eighteen of thirty days is enough", user: daily(now, repeat(80, 18)...), days: 30, want: 80},
+		{name: "seventeen of thirty days is not", user: daily(now, repeat(80, 17)...), days: 30, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.user.MovingAverageWeight(now, test.days, test.shift)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got %.2f, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("got %.2f, want %.2f", got, test.want)
+			}
+		})
+	}
+}
+
+func repeat(kg float64, n int) []float64 {
+	kgs := make([]float64, n)
+	for i := range kgs {
+		kgs[i] = kg
+	}
+	return kgs
+}
+
+func TestToastNotifications(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		user *User
+		want string
+	}{
+		{name: "five-day average down", user: daily(now, 80, 81, 82, 83, 84, 85), want: "toast"},
+		{name: "five-day average up, thirty-day down", user: daily(now, append([]float64{90, 90}, repeat(80, 29)...)...), want: "encouragement"},
+		{name: "not enough data", user: daily(now, 80), want: "not-enough-data"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var kinds []string
+			for _, note := range test.user.ToastNotifications(now) {
+				if note.Kind != "milestone" {
+					kinds = append(kinds, note.Kind)
+				}
+			}
+			if len(kinds) != 1 || kinds[0] != test.want {
+				t.Errorf("got %v, want [%s]", kinds, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdateUserConcurrent(t *testing.T) {
+	db := testDb(t)
+	if err := (&User{Username: "busy"}).Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each writer changes something different; none may be lost.
+	const writers = 20
+	start := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := UpdateUser(db, "busy", func(u *User) error {
+				u.LogWeight(80+float64(i), start.Add(time.Duration(i)*time.Hour))
+				switch i {
+				case 0:
+					u.Paused = true
+				case 1:
+					u.ReminderDays = 3
+				case 2:
+					u.LastSummary = start
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	u, err := LoadUser(db, "busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Weights) != writers {
+		t.Errorf("got %d weights, want %d", len(u.Weights), writers)
+	}
+	if !u.Paused || u.ReminderDays != 3 || !u.LastSummary.Equal(start) {
+		t.Errorf("got paused=%t, reminder days=%d, last summary=%s; want every update kept",
+			u.Paused, u.ReminderDays, u.LastSummary)
+	}
+}
+
+func TestUpdateUserError(t *testing.T) {
+	db := testDb(t)
+	if err := (&User{Username: "careful", ReminderDays: 2}).Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("changed my mind")
+	_, err := UpdateUser(db, "careful", func(u *User) error {
+		u.ReminderDays = 5
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+	if u, err := LoadUser(db, "careful"); err != nil || u.ReminderDays != 2 {
+		t.Errorf("after a failed update, got %+v (%v), want it unchanged", u, err)
+	}
+
+	if _, err := UpdateUser(db, "nobody", func(u *User) error { return nil }); !errors.Is(err, UserNotFound) {
+		t.Errorf("updating a missing user, got error %v, want %v", err, UserNotFound)
+	}
+}
+
+func TestRenameKeepsConcurrentUpdates(t *testing.T) {
+	db := testDb(t)
+	stale := &User{Username: "before"}
+	if err := stale.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Made after the handler loaded its copy, but before the rename.
+	if _, err := UpdateUser(db, "before", func(u *User) error {
+		u.LogWeight(80, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stale.Rename(db, "After"); err != nil {
+		t.Fatal(err)
+	}
+	if stale.Username != "after" {
+		t.Errorf("got username %q, want %q", stale.Username, "after")
+	}
+	u, err := LoadUser(db, "after")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Weights) != 1 {
+		t.Errorf("got %d weights after renaming, want 1", len(u.Weights))
+	}
+	if _, err := LoadUser(db, "before"); !errors.Is(err, UserNotFound) {
+		t.Errorf("old name: got error %v, want %v", err, UserNotFound)
+	}
+}
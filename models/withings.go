@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/asymmetricia/withings"
+	"golang.org/x/oauth2"
+)
+
+// Withings is a withings API client whose requests are all sent through Http,
+// e.g. so that they can be counted without touching anyone else's.
+//
+// The withings library sends token requests through http.DefaultClient
+// regardless, so those are made here instead; only its API calls are left to
+// it, with Http passed along in their context.
+type Withings struct {
+	*withings.Client
+	Http *http.Client
+}
+
+// NewWithings returns a client for the app with the given credentials and
+// OAuth callback that sends its requests through httpClient.
+func NewWithings(clientId, clientSecret, callback string, httpClient *http.Client) *Withings {
+	client := withings.NewClient(clientId, clientSecret, callback)
+	return &Withings{Client: &client, Http: httpClient}
+}
+
+// Context returns ctx, carrying w.Http for the withings library's API calls.
+func (w *Withings) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, w.Http)
+}
+
+// UserFromAuthCode exchanges the authorization code a user was sent back with
+// for their tokens.
+func (w *Withings) UserFromAuthCode(ctx context.Context, code string) (*withings.User, error) {
+	token, err := w.requestToken(ctx, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {w.OAuth2Config.RedirectURL},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	return w.NewUserFromAccessToken(ctx, token.AccessToken, token.Expiry, token.RefreshToken)
+}
+
+// UserFromTokens returns the user the tokens are for, first refreshing them if
+// the access token has expired. The user's tokens may then differ from those
+// given, and should be saved if so.
+func (w *Withings) UserFromTokens(ctx context.Context, access string, expiry time.Time, refresh string) (*withings.User, error) {
+	if !expiry.After(time.Now()) {
+		token, err := w.requestToken(ctx, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refresh},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("refreshing token: %w", err)
+		}
+		access, expiry, refresh = token.AccessToken, token.Expiry, token.RefreshToken
+	}
+	return w.NewUserFromAccessToken(ctx, access, expiry, refresh)
+}
+
+// requestToken asks withings for new tokens, as form's grant_type says.
+func (w *Withings) requestToken(ctx context.Context, form url.Values) (*oauth2.Token, error) {
+	form.Set("action", "requesttoken")
+	form.Set("client_id", w.OAuth2Config.ClientID)
+	form.Set("client_secret", w.OAuth2Config.ClientSecret)
+
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.OAuth2Config.Endpoint.TokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	// WithingsRoundTripper unwraps the response body from withings' envelope,
+	// turning a non-zero status into an error.
+	res, err := (*withings.WithingsRoundTripper)(w.Http).RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("non-2XX %d from server: %q", res.StatusCode, string(body))
+	}
+
+	var response struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		TokenType    string `json:"token_type"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &oauth2.Token{
+		AccessToken:  response.AccessToken,
+		TokenType:    response.TokenType,
+		RefreshToken: response.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(response.ExpiresIn) * time.Second),
+	}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	. "github.com/asymmetricia/vator/log"
+)
+
+// RequestIdHeader carries a request's ID: from a proxy in front of vator, if
+// it sets one, and back to the client.
+const RequestIdHeader = "X-Request-Id"
+
+// validRequestId limits the IDs accepted from clients to ones that are safe to
+// log and echo.
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// httpLog is the logger for the http subsystem, which each request's logger
+// extends.
+var httpLog = New("http")
+
+// RequestLog gives each request an ID and a logger that adds the ID, the
+// route returned by route, and the method to each line; handlers get it with
+// reqLog.
+func RequestLog(route func(*http.Request) string) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(RequestIdHeader)
+			if !validRequestId.MatchString(id) {
+				id = newRequestId()
+			}
+			rw.Header().Set(RequestIdHeader, id)
+
+			logger := httpLog.With("request_id", id, "route", route(req), "method", req.Method)
+			handler.ServeHTTP(rw, req.WithContext(WithContext(req.Context(), logger)))
+		})
+	}
+}
+
+// AccessLog logs each request once it's been handled: its path, status, how
+// long it took, and the user, if RequireAuth found one. It must follow
+// RequestLog, whose logger adds the rest.
+func AccessLog(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{}
+		recorder := &responseRecorder{ResponseWriter: rw, status: http.StatusOK}
+		handler.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), accessKey{}, entry)))
+
+		logger := reqLog(req).With(
+			"path", req.URL.Path,
+			"status", recorder.status,
+			"latency_ms", float64(time.Since(start).Microseconds())/1000)
+		if entry.user != "" {
+			logger = logger.With("user", entry.user)
+		}
+		logger.Infof("%s %s %d", req.Method, req.URL.Path, recorder.status)
+	})
+}
+
+type accessKey struct{}
+
+// accessEntry collects what AccessLog logs that's only known further in.
+type accessEntry struct {
+	user string
+}
+
+// setAccessUser records the user a request is from, for AccessLog.
+func setAccessUser(req *http.Request, user string) {
+	if entry, ok := req.Context().Value(accessKey{}).(*accessEntry); ok {
+		entry.user = user
+	}
+}
+
+// responseRecorder remembers the status a handler writes, and whether it has
+// written anything.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		Log.Errorf("generating request ID: %s", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// reqLog returns the request's logger, which adds its ID, route and user, if
+// known, to each line.
+func reqLog(req *http.Request) *Logger {
+	return FromContext(req.Context())
+}
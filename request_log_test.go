@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/asymmetricia/vator/log"
+)
+
+func TestRequestLog(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Configure(&buf, "json", "info"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Configure(os.Stderr, "text", "info") })
+
+	handler := RequestLog(func(*http.Request) string { return "/phone" })(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			Bail(rw, req, errors.New("texting +15555550123 failed"), http.StatusBadGateway)
+		}))
+
+	req := httptest.NewRequest("POST", "/phone", nil)
+	req.Header.Set(RequestIdHeader, "from-the-proxy.1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if id := rec.Header().Get(RequestIdHeader); id != "from-the-proxy.1" {
+		t.Errorf("got request ID %q, want the proxy's", id)
+	}
+	if !strings.Contains(rec.Body.String(), "<code>from-the-proxy.1</code>") {
+		t.Errorf("error page %q doesn't give the request ID", rec.Body)
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	for key, want := range map[string]interface{}{
+		"subsystem":  "http",
+		"request_id": "from-the-proxy.1",
+		"route":      "/phone",
+		"method":     "POST",
+		"msg":        "texting +*********23 failed",
+	} {
+		if line[key] != want {
+			t.Errorf("got %s=%v, want %v", key, line[key], want)
+		}
+	}
+	if source, _ := line["source"].(string); !strings.HasPrefix(source, "request_log_test.go:") {
+		t.Errorf("got source %q, want Bail's caller", line["source"])
+	}
+}
+
+func TestRequestIdGenerated(t *testing.T) {
+	handler := RequestLog(func(*http.Request) string { return "/" })(
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+
+	seen := map[string]bool{}
+	for _, given := range []string{"", "has spaces", strings.Repeat("x", 65)} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIdHeader, given)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		id := rec.Header().Get(RequestIdHeader)
+		if id == given || !validRequestId.MatchString(id) || seen[id] {
+			t.Errorf("given %q, got request ID %q", given, id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Middleware wraps a handler, e.g. to require a login, or to log or recover
+// from what the handler does.
+type Middleware func(http.Handler) http.Handler
+
+// Chain returns handler wrapped in middleware, the first outermost.
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Router routes requests by path, like http.ServeMux, and then by method,
+// answering 405 for methods a route doesn't allow. Routes added through a
+// Router returned by With are behind its middleware.
+type Router struct {
+	mux        *http.ServeMux
+	routes     map[string]methods
+	middleware []Middleware
+}
+
+func NewRouter() *Router {
+	return &Router{mux: http.NewServeMux(), routes: map[string]methods{}}
+}
+
+// With returns a Router that adds routes to r's, behind r's middleware and
+// then middleware.
+func (r *Router) With(middleware ...Middleware) *Router {
+	ret := *r
+	ret.middleware = append(r.middleware[:len(r.middleware):len(r.middleware)], middleware...)
+	return &ret
+}
+
+// Handle routes requests for path with any of the given methods to handler.
+// A GET route also answers HEAD. path is a pattern as for http.ServeMux; each
+// path and method may be routed only once.
+func (r *Router) Handle(path string, handler http.Handler, allow ...string) {
+	if len(allow) == 0 {
+		panic(fmt.Sprintf("route %q allows no methods", path))
+	}
+
+	route, ok := r.routes[path]
+	if !ok {
+		route = methods{}
+		r.routes[path] = route
+		r.mux.Handle(path, route)
+	}
+	handler = Chain(handler, r.middleware...)
+	for _, method := range allow {
+		if _, ok := route[method]; ok {
+			panic(fmt.Sprintf("%s %s is routed twice", method, path))
+		}
+		route[method] = handler
+	}
+}
+
+func (r *Router) HandleFunc(path string, handler http.HandlerFunc, allow ...string) {
+	r.Handle(path, handler, allow...)
+}
+
+func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(rw, req)
+}
+
+// Route returns the path pattern that routes req, to label its logs and
+// metrics.
+func (r *Router) Route(req *http.Request) string {
+	_, pattern := r.mux.Handler(req)
+	return pattern
+}
+
+// methods is a route's handler for each method it allows.
+type methods map[string]http.Handler
+
+func (m methods) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	handler, ok := m[req.Method]
+	if !ok && req.Method == http.MethodHead {
+		handler, ok = m[http.MethodGet]
+	}
+	if !ok {
+		rw.Header().Set("Allow", m.allow())
+		http.Error(rw, "That isn't something you can do here.", http.StatusMethodNotAllowed)
+		return
+	}
+	handler.ServeHTTP(rw, req)
+}
+
+// allow lists the methods m allows, for the Allow header.
+func (m methods) allow() string {
+	var ret []string
+	for method := range m {
+		ret = append(ret, method)
+	}
+	if _, ok := m[http.MethodGet]; ok {
+		if _, ok := m[http.MethodHead]; !ok {
+			ret = append(ret, http.MethodHead)
+		}
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ", ")
+}
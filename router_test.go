@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/asymmetricia/vator/log"
+)
+
+// tag returns middleware that appends name to the X-Chain response header,
+// to show the order middleware runs in.
+func tag(name string) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Add("X-Chain", name)
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func respond(body string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) { rw.Write([]byte(body)) }
+}
+
+func serve(handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestRouterMethods(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc("/rename", respond("form"), "GET")
+	router.HandleFunc("/rename", respond("renamed"), "POST")
+	router.HandleFunc("/kgs", respond("toggled"), "POST")
+
+	for _, test := range []struct {
+		method, target string
+		status         int
+		body, allow    string
+	}{
+		{method: "GET", target: "/rename", status: 200, body: "form"},
+		{method: "HEAD", target: "/rename", status: 200},
+		{method: "POST", target: "/rename", status: 200, body: "renamed"},
+		{method: "DELETE", target: "/rename", status: 405, allow: "GET, HEAD, POST"},
+		{method: "GET", target: "/kgs", status: 405, allow: "POST"},
+		{method: "GET", target: "/nope", status: 404},
+	} {
+		rec := serve(router, test.method, test.target)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.target, rec.Code, test.status)
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("%s %s: got %q, want %q", test.method, test.target, rec.Body, test.body)
+		}
+		if allow := rec.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s: got Allow %q, want %q", test.method, test.target, allow, test.allow)
+		}
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	router := NewRouter()
+	outer := router.With(tag("outer"))
+	outer.With(tag("inner")).HandleFunc("/summary", respond("ok"), "GET")
+	outer.HandleFunc("/history", respond("ok"), "GET")
+	router.HandleFunc("/healthz", respond("ok"), "GET")
+
+	for path, want := range map[string]string{
+		"/summary": "outer,inner",
+		"/history": "outer",
+		"/healthz": "",
+	} {
+		rec := serve(Chain(router, tag("first"), tag("second")), "GET", path)
+		got := strings.Join(rec.Header().Values("X-Chain"), ",")
+		if want = strings.TrimSuffix("first,second,"+want, ","); got != want {
+			t.Errorf("%s: ran %q, want %q", path, got, want)
+		}
+	}
+
+	// Methods are checked before the route's middleware runs.
+	if rec := serve(router, "POST", "/summary"); rec.Code != 405 || rec.Header().Get("X-Chain") != "" {
+		t.Errorf("POST /summary: got %d after %q, want 405 before any middleware", rec.Code, rec.Header().Values("X-Chain"))
+	}
+
+	if route := router.Route(httptest.NewRequest("GET", "/static/js/graph.js", nil)); route != "" {
+		t.Errorf("got route %q for an unrouted path, want none", route)
+	}
+	if route := router.Route(httptest.NewRequest("GET", "/summary?x=1", nil)); route != "/summary" {
+		t.Errorf("got route %q, want /summary", route)
+	}
+}
+
+func TestRouterRoutedTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("routing GET /kgs twice didn't panic")
+		}
+	}()
+	router := NewRouter()
+	router.HandleFunc("/kgs", respond("ok"), "GET")
+	router.HandleFunc("/kgs", respond("ok"), "GET", "POST")
+}
+
+func TestAccessLogAndRecover(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Configure(&buf, "json", "info"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Configure(os.Stderr, "text", "info") })
+
+	router := NewRouter()
+	router.HandleFunc("/report", func(rw http.ResponseWriter, req *http.Request) {
+		setAccessUser(req, "alice")
+		var report map[string]int
+		report["month"]++
+	}, "GET")
+	handler := Chain(router, RequestLog(router.Route), AccessLog, Recover)
+
+	rec := serve(handler, "GET", "/report?period=month")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", rec.Code)
+	}
+	id := rec.Header().Get(RequestIdHeader)
+	if body := rec.Body.String(); !strings.Contains(body, "Very sorry!") || !strings.Contains(body, id) {
+		t.Errorf("got %q, want the error page with request ID %q", body, id)
+	}
+
+	var lines []map[string]interface{}
+	for dec := json.NewDecoder(&buf); dec.More(); {
+		var line map[string]interface{}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want the panic and the access log: %v", len(lines), lines)
+	}
+
+	panicked, access := lines[0], lines[1]
+	if msg, _ := panicked["msg"].(string); !strings.HasPrefix(msg, "panic: assignment to entry in nil map") ||
+		!strings.Contains(panicked["stack"].(string), "router_test.go") {
+		t.Errorf("got panic line %v", panicked)
+	}
+	for key, want := range map[string]interface{}{
+		"msg":        "GET /report 500",
+		"path":       "/report",
+		"route":      "/report",
+		"status":     float64(500),
+		"user":       "alice",
+		"request_id": id,
+	} {
+		if access[key] != want {
+			t.Errorf("got access log %s=%v, want %v", key, access[key], want)
+		}
+	}
+	if _, ok := access["latency_ms"].(float64); !ok {
+		t.Errorf("got access log latency_ms=%v, want a number", access["latency_ms"])
+	}
+}
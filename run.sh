@@ -1,12 +1,6 @@
 #!/bin/sh
 
-/vator \
-  -db-file="$DB_FILE" \
-  -consumer-key="$CONSUMER_KEY" \
-  -consumer-secret="$CONSUMER_SECRET" \
-  -twilio-sid="$TWILIO_SID" \
-  -twilio-token="$TWILIO_TOKEN" \
-  -callback-domain="$FQDN" \
-  -callback-proto="${CALLBACK_PROTO:-http}" \
-  -callback-port="${CALLBACK_PORT:-80}" \
-  ${TLS:+--tls}
+# vator reads its configuration from the environment (e.g. VATOR_DB_FILE,
+# VATOR_CONSUMER_KEY, VATOR_CALLBACK_DOMAIN), and from the file named by
+# VATOR_CONFIG, if any.
+exec /vator "$@"
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+// schedulerResync bounds how long the scheduler sleeps, so that users who
+// appear without a Reschedule, e.g. newly linked ones, are picked up.
+const schedulerResync = 15 * time.Minute
+
+// Scheduler runs per-user jobs, like summaries, at the times each user has
+// chosen, according to Clock. It sleeps until the earliest job is due rather
+// than polling.
+type Scheduler struct {
+	Db    *bbolt.DB
+	Jobs  []models.Job
+	Clock models.Clock
+
+	wake chan struct{}
+}
+
+func NewScheduler(db *bbolt.DB, jobs ...models.Job) *Scheduler {
+	return &Scheduler{
+		Db:    db,
+		Jobs:  jobs,
+		Clock: models.WallClock,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Reschedule tells the scheduler that users' schedules have changed, so that
+// it recomputes when it should next wake.
+func (s *Scheduler) Reschedule() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs jobs as they come due, until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		next := s.RunDue(s.Clock.Now())
+
+		wait := schedulerResync
+		if !next.IsZero() && next.Sub(s.Clock.Now()) < wait {
+			wait = next.Sub(s.Clock.Now())
+		}
+		Log.Debugf("scheduler sleeping for %s", wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// RunDue runs every job that is due as of now and returns when the next one
+// will be, or the zero time if none are scheduled.
+func (s *Scheduler) RunDue(now time.Time) time.Time {
+	var next time.Time
+	for _, u := range models.GetUsers(s.Db) {
+		for _, job := range s.Jobs {
+			due := job.Next(u, now)
+			if due.IsZero() {
+				continue
+			}
+			if !due.After(now) {
+				Log.Debugf("running %s for %q, due at %s", job.Name, u.Username, due)
+				job.Run(u)
+				if due = job.Next(u, now); due.IsZero() || !due.After(now) {
+					continue
+				}
+			}
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asymmetricia/vator/models"
+)
+
+func TestSchedulerSummaries(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := testDb(t)
+
+	clock := models.NewFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, ny))
+
+	notifier := models.NewNotifier(db, nil, nil)
+	notifier.DryRun = true
+	notifier.Clock = clock
+	scheduler := NewScheduler(db, models.SummaryJob(db, notifier))
+	scheduler.Clock = clock
+
+	// Only users linked to withings are scheduled.
+	user := &models.User{Username: "sunday", TimezoneName: "America/New_York", SummaryHour: 9, RefreshSecret: "linked"}
+	if err := user.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries := func() int {
+		msgs, err := models.ListOutbox(db, func(m *models.OutboundMessage) bool { return m.Kind == "summary" })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(msgs)
+	}
+
+	sunday := time.Date(2026, 10, 18, 9, 0, 0, 0, ny)
+	if next := scheduler.RunDue(clock.Now()); !next.Equal(sunday) {
+		t.Errorf("on saturday, next run is %s, want %s", next, sunday)
+	}
+	if n := summaries(); n != 0 {
+		t.Fatalf("on saturday, got %d summaries, want none", n)
+	}
+
+	clock.Set(sunday)
+	if next := scheduler.RunDue(clock.Now()); !next.Equal(sunday.AddDate(0, 0, 7)) {
+		t.Errorf("after sunday's summary, next run is %s, want %s", next, sunday.AddDate(0, 0, 7))
+	}
+	if n := summaries(); n != 1 {
+		t.Fatalf("on sunday, got %d summaries, want 1", n)
+	}
+
+	clock.Advance(time.Hour)
+	scheduler.RunDue(clock.Now())
+	if n := summaries(); n != 1 {
+		t.Fatalf("an hour later, got %d summaries, want still 1", n)
+	}
+}
+
+func TestSchedulerRunStops(t *testing.T) {
+	db := testDb(t)
+	scheduler := NewScheduler(db, models.SummaryJob(db, models.NewNotifier(db, nil, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once cancelled")
+	}
+}
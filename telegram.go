@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/asymmetricia/vator/log"
+	"github.com/asymmetricia/vator/models"
+	"go.etcd.io/bbolt"
+)
+
+const telegramHelp = `Hi! I'm vator. Here's what I can do:
+/today - today's weigh-ins and your moving averages
+/week - your weekly summary
+/graph - a link to your graph
+/log <weight> - record a weight by hand`
+
+// TelegramBot polls Telegram for messages sent to the bot, links chats to
+// vator users, and answers their commands.
+type TelegramBot struct {
+	Db       *bbolt.DB
+	Telegram *models.Telegram
+	Notifier *models.Notifier
+	BaseUrl  string
+}
+
+// Run processes incoming messages until ctx is done.
+func (b *TelegramBot) Run(ctx context.Context) {
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := b.Telegram.GetUpdates(ctx, offset, 30*time.Second)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			Log.Warningf("polling telegram for updates: %s", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			if update.UpdateId >= offset {
+				offset = update.UpdateId + 1
+			}
+			if update.Message == nil || update.Message.Text == "" {
+				continue
+			}
+			b.Handle(update.Message)
+		}
+	}
+}
+
+// reply sends msg to chatId, recording it in user's notification history if
+// the chat is linked to one.
+func (b *TelegramBot) reply(user *models.User, chatId int64, msg string) {
+	id, err := b.Telegram.SendMessage(chatId, msg)
+	if user != nil {
+		models.RecordSent(b.Db, user.Username, models.ChannelTelegram, models.Notification{Kind: "reply", Body: msg}, id, err)
+	}
+	if err != nil {
+		Log.Errorf("replying to telegram chat %d: %s", chatId, err)
+	}
+}
+
+func (b *TelegramBot) Handle(msg *models.TelegramMessage) {
+	chatId := msg.Chat.Id
+	fields := strings.Fields(msg.Text)
+	if len(fields) == 0 {
+		return
+	}
+
+	// Commands may be addressed to us explicitly, as in `/today@vator_bot`.
+	command := strings.ToLower(strings.SplitN(fields[0], "@", 2)[0])
+	args := fields[1:]
+
+	user, err := models.FindUser(b.Db, func(u *models.User) bool { return u.TelegramChatId == chatId })
+	if errors.Is(err, models.UserNotFound) {
+		b.link(chatId, command, args)
+		return
+	}
+	if err != nil {
+		Log.Errorf("finding user for telegram chat %d: %s", chatId, err)
+		b.reply(user, chatId, "very sorry! afraid something went wrong...")
+		return
+	}
+
+	switch command {
+	case "/today":
+		b.reply(user, chatId, user.TodayMessage(b.Notifier.Clock.Now()))
+	case "/week":
+		b.reply(user, chatId, user.SummaryMessage(b.Notifier.Clock.Now(), 7))
+	case "/graph":
+		b.reply(user, chatId, b.BaseUrl+"graph?user="+url.QueryEscape(user.Username))
+	case "/log":
+		b.reply(user, chatId, logWeightCommand(b.Db, b.Notifier, user, command, args))
+	default:
+		b.reply(user, chatId, telegramHelp)
+	}
+}
+
+// link associates chatId with the user whose one-time code was sent, either as
+// `/start CODE` or by itself.
+func (b *TelegramBot) link(chatId int64, command string, args []string) {
+	code := command
+	if command == "/start" && len(args) > 0 {
+		code = args[0]
+	}
+	code = strings.ToUpper(code)
+
+	user, err := models.FindUser(b.Db, func(u *models.User) bool {
+		return u.TelegramCode != "" && u.TelegramCode == code
+	})
+	if err != nil {
+		if !errors.Is(err, models.UserNotFound) {
+			Log.Errorf("finding user for telegram code: %s", err)
+		}
+		b.reply(user, chatId, "Hi! To link this chat to vator, send me the code shown on your vator home page.")
+		return
+	}
+
+	linked, err := models.UpdateUser(b.Db, user.Username, func(u *models.User) error {
+		u.TelegramChatId = chatId
+		u.TelegramCode = ""
+		return nil
+	})
+	if err != nil {
+		Log.Errorf("saving telegram link for %q: %s", user.Username, err)
+		b.reply(user, chatId, "very sorry! afraid something went wrong...")
+		return
+	}
+	user = linked
+
+	Log.Infof("user %q linked telegram chat %d", user.Username, chatId)
+	b.reply(user, chatId, fmt.Sprintf("Linked to %s! You'll get your updates here.\n\n%s", user.Username, telegramHelp))
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"embed"
 	"html/template"
+	"time"
+
+	"github.com/asymmetricia/vator/models"
 )
 
 type TemplateContext struct {
@@ -11,11 +14,60 @@ type TemplateContext struct {
 	Phone string
 	Kgs   bool
 
+	PhoneVerified bool
+	PendingPhone  string
+
+	SmsOptedOut   bool
+	SmsConsentLog []models.ConsentChange
+
 	Withings bool
 
+	TimezoneName string
+	QuietStart   int
+	QuietEnd     int
+	DailyCap     int
+	ReminderDays int
+	Hours        []int
+
+	SummaryCadence  string
+	SummaryCadences []models.SummaryCadence
+	SummaryDay      time.Weekday
+	SummaryHour     int
+	Weekdays        []time.Weekday
+	Reports         bool
+
+	MilestoneStart string
+	Height         string
+	Milestones     []models.Milestone
+
+	Language  string
+	Languages []string
+	Tone      string
+	Tones     []string
+	Previews  []models.MessagePreview
+
+	TelegramBot    string
+	TelegramCode   string
+	TelegramLinked bool
+
 	User  string
 	Page  string
 	Share bool
+
+	History  []*models.OutboundMessage
+	Timezone *time.Location
+
+	Report      *models.Report
+	ReportStats []ReportStat
+	ReportPrev  string
+	ReportNext  string
+	GraphUrl    string
+
+	// Embed drops the page's navigation, for pages shown within others.
+	Embed bool
+
+	// RequestId identifies the request that failed, on the error page.
+	RequestId string
 }
 
 //go:embed templates/*
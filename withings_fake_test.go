@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asymmetricia/vator/metrics"
+	"github.com/asymmetricia/vator/models"
+	"github.com/asymmetricia/withings"
+	"github.com/asymmetricia/withings/enum/meastype"
+	"go.etcd.io/bbolt"
+)
+
+// The hosts the withings library talks to; fakeWithingsTransport sends their
+// requests to the fake instead.
+const (
+	withingsApiHost     = "wbsapi.withings.net"
+	withingsAccountHost = "account.withings.com"
+)
+
+const (
+	fakeClientId     = "vator-test"
+	fakeClientSecret = "vator-test-secret"
+	fakeCallback     = "http://vator.test/callback"
+)
+
+// fakeWithings is an in-process stand-in for the parts of the Withings API
+// vator uses: the authorization page, the token endpoint, getmeas, and notify
+// subscribe. Each authorization code and refresh token may be used once, as
+// with the real API, and measurements are scripted with AddWeight.
+type fakeWithings struct {
+	*httptest.Server
+
+	// ExpiresIn is the lifetime, in seconds, of the access tokens issued.
+	ExpiresIn int
+
+	mu            sync.Mutex
+	next          int
+	codes         map[string]bool
+	access        map[string]bool
+	refresh       map[string]bool
+	weights       []withings.Weight
+	subscriptions []url.Values
+	refreshes     int
+	getmeas       int
+}
+
+// newFakeWithings starts a fake Withings server, to which the clients from
+// Client send their requests, until the test ends.
+func newFakeWithings(t *testing.T) *fakeWithings {
+	t.Helper()
+	f := &fakeWithings{
+		ExpiresIn: 10800,
+		codes:     map[string]bool{},
+		access:    map[string]bool{},
+		refresh:   map[string]bool{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Client returns a withings client configured as vator would be, with the
+// fake's credentials and a callback that the tests hand to Complete. Its
+// requests are counted as in main, so tests can check the metrics too.
+func (f *fakeWithings) Client() *models.Withings {
+	return models.NewWithings(fakeClientId, fakeClientSecret, fakeCallback, &http.Client{
+		Transport: &metrics.Transport{Base: &fakeWithingsTransport{host: f.Listener.Addr().String(), base: http.DefaultTransport}},
+	})
+}
+
+// AddWeight scripts a weigh-in, to be returned by getmeas for any range that
+// includes date.
+func (f *fakeWithings) AddWeight(date time.Time, kgs float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.weights = append(f.weights, withings.Weight{Date: date.Truncate(time.Second), Kgs: kgs})
+}
+
+// Link issues tokens as if a user had just authorized vator, returning the
+// access and refresh tokens.
+func (f *fakeWithings) Link() (access, refresh string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.issue()
+}
+
+// Refreshes is how many times a refresh token has been exchanged.
+func (f *fakeWithings) Refreshes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refreshes
+}
+
+// Getmeas is how many getmeas requests have succeeded.
+func (f *fakeWithings) Getmeas() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.getmeas
+}
+
+// Subscriptions are the parameters of each notify subscribe request.
+func (f *fakeWithings) Subscriptions() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]url.Values(nil), f.subscriptions...)
+}
+
+// RefreshValid reports whether refresh may still be exchanged for new tokens.
+func (f *fakeWithings) RefreshValid(refresh string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refresh[refresh]
+}
+
+// issue creates a new pair of tokens; f.mu must be held.
+func (f *fakeWithings) issue() (access, refresh string) {
+	f.next++
+	access = fmt.Sprintf("access-%d", f.next)
+	refresh = fmt.Sprintf("refresh-%d", f.next)
+	f.access[access] = true
+	f.refresh[refresh] = true
+	return access, refresh
+}
+
+func (f *fakeWithings) serve(rw http.ResponseWriter, req *http.Request) {
+	// The library requests tokens by code without a content-type, which the
+	// real API accepts.
+	if req.Method == http.MethodPost && req.Header.Get("content-type") == "" {
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch req.Host + req.URL.Path {
+	case withingsAccountHost + "/oauth2_user/authorize2":
+		f.authorize(rw, req)
+	case withingsApiHost + "/v2/oauth2":
+		f.token(rw, req)
+	case withingsApiHost + "/measure":
+		if f.authorized(rw, req) {
+			f.measure(rw, req)
+		}
+	case withingsApiHost + "/notify":
+		if f.authorized(rw, req) {
+			f.notify(rw, req)
+		}
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+// reply writes a response in Withings' envelope; a non-zero status is an
+// error, described by body.
+func (f *fakeWithings) reply(rw http.ResponseWriter, status int, body interface{}) {
+	res := map[string]interface{}{"status": status}
+	if status == 0 {
+		res["body"] = body
+	} else {
+		res["error"] = body
+	}
+	rw.Header().Set("content-type", "application/json")
+	json.NewEncoder(rw).Encode(res)
+}
+
+// authorize stands in for the user approving vator's access: it redirects
+// straight back to the callback with a fresh code.
+func (f *fakeWithings) authorize(rw http.ResponseWriter, req *http.Request) {
+	if req.Form.Get("client_id") != fakeClientId || req.Form.Get("redirect_uri") != fakeCallback {
+		http.Error(rw, "unknown client or redirect", http.StatusBadRequest)
+		return
+	}
+	f.next++
+	code := fmt.Sprintf("code-%d", f.next)
+	f.codes[code] = true
+
+	callback, _ := url.Parse(req.Form.Get("redirect_uri"))
+	callback.RawQuery = url.Values{"code": {code}, "state": {req.Form.Get("state")}}.Encode()
+	http.Redirect(rw, req, callback.String(), http.StatusFound)
+}
+
+func (f *fakeWithings) token(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || req.Form.Get("action") != "requesttoken" {
+		f.reply(rw, 2555, "unknown action")
+		return
+	}
+	if req.Form.Get("client_id") != fakeClientId || req.Form.Get("client_secret") != fakeClientSecret {
+		f.reply(rw, 503, "invalid client")
+		return
+	}
+
+	switch req.Form.Get("grant_type") {
+	case "authorization_code":
+		code := req.Form.Get("code")
+		if !f.codes[code] || req.Form.Get("redirect_uri") != fakeCallback {
+			f.reply(rw, 503, "invalid code")
+			return
+		}
+		delete(f.codes, code)
+	case "refresh_token":
+		refresh := req.Form.Get("refresh_token")
+		if !f.refresh[refresh] {
+			f.reply(rw, 503, "invalid refresh_token")
+			return
+		}
+		delete(f.refresh, refresh)
+		f.refreshes++
+	default:
+		f.reply(rw, 503, "invalid grant_type")
+		return
+	}
+
+	access, refresh := f.issue()
+	f.reply(rw, 0, map[string]interface{}{
+		"userid":        12345,
+		"access_token":  access,
+		"refresh_token": refresh,
+		"expires_in":    f.ExpiresIn,
+		"scope":         "user.activity,user.metrics,user.info",
+		"token_type":    "Bearer",
+	})
+}
+
+// authorized checks the request's bearer token, replying with an error if it
+// isn't one the fake issued.
+func (f *fakeWithings) authorized(rw http.ResponseWriter, req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("authorization"), "Bearer ")
+	if !f.access[token] {
+		f.reply(rw, 401, "invalid access token")
+		return false
+	}
+	return true
+}
+
+func (f *fakeWithings) measure(rw http.ResponseWriter, req *http.Request) {
+	if req.Form.Get("action") != "getmeas" {
+		f.reply(rw, 2555, "unknown action")
+		return
+	}
+
+	from, to := int64(0), int64(math.MaxInt64)
+	for name, v := range map[string]*int64{"startdate": &from, "enddate": &to} {
+		if s := req.Form.Get(name); s != "" {
+			var err error
+			if *v, err = strconv.ParseInt(s, 10, 64); err != nil {
+				f.reply(rw, 503, "invalid "+name)
+				return
+			}
+		}
+	}
+
+	type measure struct {
+		Value int               `json:"value"`
+		Type  meastype.MeasType `json:"type"`
+		Unit  int               `json:"unit"`
+	}
+	type group struct {
+		GrpId    int       `json:"grpid"`
+		Attrib   int       `json:"attrib"`
+		Date     int64     `json:"date"`
+		Category int       `json:"category"`
+		Measures []measure `json:"measures"`
+	}
+
+	groups := []group{}
+	for i, w := range f.weights {
+		if date := w.Date.Unix(); date >= from && date <= to {
+			groups = append(groups, group{
+				GrpId:    i + 1,
+				Date:     date,
+				Category: 1,
+				Measures: []measure{{Value: int(math.Round(w.Kgs * 1000)), Type: meastype.Weight, Unit: -3}},
+			})
+		}
+	}
+	// Withings returns the newest first.
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Date > groups[j].Date })
+
+	f.getmeas++
+	f.reply(rw, 0, map[string]interface{}{
+		"updatetime":  time.Now().Unix(),
+		"timezone":    "UTC",
+		"measuregrps": groups,
+	})
+}
+
+func (f *fakeWithings) notify(rw http.ResponseWriter, req *http.Request) {
+	if req.Form.Get("action") != "subscribe" {
+		f.reply(rw, 2555, "unknown action")
+		return
+	}
+	if _, err := url.ParseRequestURI(req.Form.Get("callbackurl")); err != nil {
+		f.reply(rw, 293, "invalid callbackurl")
+		return
+	}
+	f.subscriptions = append(f.subscriptions, req.Form)
+	f.reply(rw, 0, map[string]interface{}{})
+}
+
+// fakeWithingsTransport sends requests for the Withings hosts to the fake
+// server, leaving the Host header alone so the fake can tell them apart.
+type fakeWithingsTransport struct {
+	host string
+	base http.RoundTripper
+}
+
+func (t *fakeWithingsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == withingsApiHost || req.URL.Host == withingsAccountHost {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+		req.URL.Host = t.host
+	}
+	return t.base.RoundTrip(req)
+}
+
+func testDb(t *testing.T) *bbolt.DB {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "vator.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}